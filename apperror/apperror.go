@@ -0,0 +1,82 @@
+// Package apperror describes how a failed request is answered: its HTTP
+// status, a stable code and a message that is safe to show to users. The
+// cause is kept for the logs only.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/yookibooki/auth/repo"
+)
+
+type Error struct {
+	Status  int
+	Code    string // a message catalog key, or the status text in snake case
+	Message string // safe to show to users
+	Err     error  // the cause, never shown
+}
+
+// Error describes the cause for the logs, falling back to Message.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error answered with status and message, coded after the
+// status, e.g. "bad_request".
+func New(status int, message string) *Error {
+	return &Error{Status: status, Code: StatusCode(status), Message: message}
+}
+
+// Internal reports a server failure while doing what, e.g. "Failed to save
+// session". Users only see a generic message; what and err are logged.
+func Internal(what string, err error) *Error {
+	return &Error{
+		Status:  http.StatusInternalServerError,
+		Code:    StatusCode(http.StatusInternalServerError),
+		Message: "Internal server error",
+		Err:     wrap(what, err),
+	}
+}
+
+// From classifies err: an *Error in its chain is returned as is, repo
+// sentinels become 404 and 409, and anything else an internal error.
+func From(err error) *Error {
+	var appErr *Error
+	switch {
+	case errors.As(err, &appErr):
+		return appErr
+	case errors.Is(err, repo.ErrNotFound):
+		e := New(http.StatusNotFound, "Not found")
+		e.Err = err
+		return e
+	case errors.Is(err, repo.ErrConflict):
+		e := New(http.StatusConflict, "Already exists")
+		e.Err = err
+		return e
+	default:
+		return Internal("Unexpected error", err)
+	}
+}
+
+// StatusCode is the code of errors without a catalog key: the status text
+// in snake case, e.g. "too_many_requests".
+func StatusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func wrap(what string, err error) error {
+	if err == nil {
+		return errors.New(what)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follows the OWASP recommendation for Argon2id.
+func DefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+type Argon2idHasher struct {
+	params Argon2idParams
+	pepper *Pepper
+}
+
+// NewArgon2idHasher returns an Argon2id hasher. pepper may be nil.
+func NewArgon2idHasher(params Argon2idParams, pepper *Pepper) *Argon2idHasher {
+	return &Argon2idHasher{params: params, pepper: pepper}
+}
+
+// Hash returns a PHC string of the form
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>[,pv=<pepper version>]$<salt>$<key>.
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	pepperVersion := 0
+	if h.pepper != nil {
+		pepperVersion = h.pepper.Current()
+		password, _ = h.pepper.Apply(pepperVersion, password)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	paramStr := fmt.Sprintf("m=%d,t=%d,p=%d", h.params.Memory, h.params.Iterations, h.params.Parallelism)
+	if pepperVersion != 0 {
+		paramStr += fmt.Sprintf(",pv=%d", pepperVersion)
+	}
+
+	return fmt.Sprintf(
+		"%sv=%d$%s$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		paramStr,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Compare(hash, password string) bool {
+	params, pepperVersion, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	if pepperVersion != 0 {
+		if h.pepper == nil {
+			return false
+		}
+		var ok bool
+		if password, ok = h.pepper.Apply(pepperVersion, password); !ok {
+			return false
+		}
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, pepperVersion, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	currentPepper := 0
+	if h.pepper != nil {
+		currentPepper = h.pepper.Current()
+	}
+
+	return pepperVersion != currentPepper ||
+		params.Memory != h.params.Memory ||
+		params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism ||
+		params.KeyLength != h.params.KeyLength ||
+		uint32(len(salt)) != h.params.SaltLength
+}
+
+func (h *Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func decodeArgon2id(hash string) (params Argon2idParams, pepperVersion int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, 0, nil, nil, ErrInvalidHash
+	}
+
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, 0, nil, nil, ErrInvalidHash
+	}
+
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return params, 0, nil, nil, ErrInvalidHash
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return params, 0, nil, nil, ErrInvalidHash
+		}
+		switch name {
+		case "m":
+			params.Memory = uint32(n)
+		case "t":
+			params.Iterations = uint32(n)
+		case "p":
+			if n > 255 {
+				return params, 0, nil, nil, ErrInvalidHash
+			}
+			params.Parallelism = uint8(n)
+		case "pv":
+			pepperVersion = int(n)
+		default:
+			return params, 0, nil, nil, ErrInvalidHash
+		}
+	}
+
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, 0, nil, nil, ErrInvalidHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, 0, nil, nil, ErrInvalidHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, 0, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, pepperVersion, salt, key, nil
+}
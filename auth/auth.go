@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -25,9 +26,27 @@ type PwdResetToken struct {
 	ExpiresAt time.Time
 }
 
+// SignupToken lets the owner of an address that has no account create one,
+// see CreateSignupToken.
+type SignupToken struct {
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// EmailChangeToken confirms that the owner of Email wants it to become the
+// address of the account UserID, see CreateEmailChangeToken.
+type EmailChangeToken struct {
+	TokenHash string
+	UserID    int
+	Email     string
+	ExpiresAt time.Time
+}
+
 type Hasher interface {
 	Hash(password string) (string, error)
 	Compare(hash, password string) bool
+	NeedsRehash(hash string) bool
 }
 
 type PasswordHasher struct {
@@ -51,6 +70,15 @@ func (h *PasswordHasher) Compare(hash, password string) bool {
 	return err == nil
 }
 
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+func (h *PasswordHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
 type TokenGenerator interface {
 	Generate() (string, error)
 }
@@ -88,45 +116,30 @@ func (v *RegexEmailValidator) Validate(email string) bool {
 	return v.pattern.MatchString(email)
 }
 
+// AuthCodeManager issues the codes and tokens mailed in links. They carry
+// enough entropy to be stored as plain SHA-256 digests (HashToken), which
+// unlike salted password hashes can be looked up directly.
 type AuthCodeManager struct {
-	hasher    Hasher
 	generator TokenGenerator
 	ttl       time.Duration
 }
 
-func NewAuthCodeManager(hasher Hasher, generator TokenGenerator, ttl time.Duration) *AuthCodeManager {
+func NewAuthCodeManager(generator TokenGenerator, ttl time.Duration) *AuthCodeManager {
 	return &AuthCodeManager{
-		hasher:    hasher,
 		generator: generator,
 		ttl:       ttl,
 	}
 }
 
-func (m *AuthCodeManager) Generate(code string) (string, error) {
-	hash, err := m.hasher.Hash(code)
-	if err != nil {
-		return "", err
-	}
-	return hash, nil
-}
-
-func (m *AuthCodeManager) Verify(codeHash, code string) bool {
-	return m.hasher.Compare(codeHash, code)
-}
-
+// CreateAuthCode issues the code of a login or confirmation link.
 func (m *AuthCodeManager) CreateAuthCode(userID int, clientID, redirectURI, state string) (*AuthCode, string, error) {
 	code, err := m.generator.Generate()
 	if err != nil {
 		return nil, "", err
 	}
 
-	codeHash, err := m.Generate(code)
-	if err != nil {
-		return nil, "", err
-	}
-
 	authCode := &AuthCode{
-		CodeHash:    codeHash,
+		CodeHash:    HashToken(code),
 		UserID:      userID,
 		ClientID:    clientID,
 		RedirectURI: redirectURI,
@@ -143,16 +156,72 @@ func (m *AuthCodeManager) CreatePwdResetToken(userID int) (*PwdResetToken, strin
 		return nil, "", err
 	}
 
-	tokenHash, err := m.Generate(token)
+	resetToken := &PwdResetToken{
+		TokenHash: HashToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(m.ttl),
+	}
+
+	return resetToken, token, nil
+}
+
+// CreatePwdChangeToken issues a short-lived reset token for a user who has
+// just proven their password but must change it before logging in.
+func (m *AuthCodeManager) CreatePwdChangeToken(userID int) (*PwdResetToken, string, error) {
+	token, err := m.generator.Generate()
 	if err != nil {
 		return nil, "", err
 	}
 
 	resetToken := &PwdResetToken{
-		TokenHash: tokenHash,
+		TokenHash: HashToken(token),
 		UserID:    userID,
 		ExpiresAt: time.Now().Add(m.ttl),
 	}
 
 	return resetToken, token, nil
 }
+
+// CreateUnlockToken issues the token of an account unlock link.
+func (m *AuthCodeManager) CreateUnlockToken() (token, tokenHash string, err error) {
+	token, err = m.generator.Generate()
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// CreateSignupToken issues the token of a signup link mailed to an address
+// without an account, which proves the address when followed.
+func (m *AuthCodeManager) CreateSignupToken(email string) (*SignupToken, string, error) {
+	token, err := m.generator.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	signupToken := &SignupToken{
+		TokenHash: HashToken(token),
+		Email:     email,
+		ExpiresAt: time.Now().Add(m.ttl),
+	}
+
+	return signupToken, token, nil
+}
+
+// CreateEmailChangeToken issues the token of the link mailed to a new
+// address, which applies the change when followed.
+func (m *AuthCodeManager) CreateEmailChangeToken(userID int, email string) (*EmailChangeToken, string, error) {
+	token, err := m.generator.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	changeToken := &EmailChangeToken{
+		TokenHash: HashToken(token),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(m.ttl),
+	}
+
+	return changeToken, token, nil
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// BreachChecker reports whether a password appears in a breach corpus.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type BreachHashFormat byte
+
+const (
+	BreachSHA1 BreachHashFormat = iota + 1
+	BreachNTLM
+)
+
+func ParseBreachHashFormat(s string) (BreachHashFormat, error) {
+	switch strings.ToLower(s) {
+	case "", "sha1":
+		return BreachSHA1, nil
+	case "ntlm":
+		return BreachNTLM, nil
+	}
+	return 0, fmt.Errorf("unknown breach hash format %q", s)
+}
+
+// Digest hashes password the way the corpus does.
+func (f BreachHashFormat) Digest(password string) []byte {
+	switch f {
+	case BreachNTLM:
+		h := md4.New()
+		for _, u := range utf16.Encode([]rune(password)) {
+			h.Write([]byte{byte(u), byte(u >> 8)})
+		}
+		return h.Sum(nil)
+	default:
+		sum := sha1.Sum([]byte(password))
+		return sum[:]
+	}
+}
+
+// HashListChecker binary-searches a downloaded HIBP "ordered by hash" file,
+// one "<HEX HASH>:<COUNT>" entry per line, without loading it into memory.
+type HashListChecker struct {
+	file   *os.File
+	size   int64
+	format BreachHashFormat
+}
+
+func OpenHashListChecker(path string, format BreachHashFormat) (*HashListChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breach list: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat breach list: %w", err)
+	}
+
+	return &HashListChecker{file: file, size: info.Size(), format: format}, nil
+}
+
+func (c *HashListChecker) Close() error {
+	return c.file.Close()
+}
+
+func (c *HashListChecker) IsBreached(password string) (bool, error) {
+	target := []byte(strings.ToUpper(hex.EncodeToString(c.format.Digest(password))))
+
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		line, next, err := c.lineFrom(mid)
+		if err == io.EOF {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read breach list: %w", err)
+		}
+
+		hash, _, _ := bytes.Cut(line, []byte(":"))
+		switch cmp := bytes.Compare(bytes.ToUpper(bytes.TrimSpace(hash)), target); {
+		case cmp == 0:
+			return true, nil
+		case cmp < 0:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+
+	return false, nil
+}
+
+// lineFrom returns the first complete line starting at or after offset and
+// the offset just past it.
+func (c *HashListChecker) lineFrom(offset int64) ([]byte, int64, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+
+	buf := make([]byte, 256)
+	for {
+		n, err := c.file.ReadAt(buf, start)
+		chunk := buf[:n]
+
+		lineStart := 0
+		if offset > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				if err == io.EOF {
+					return nil, 0, io.EOF
+				}
+				if err != nil {
+					return nil, 0, err
+				}
+				start += int64(n)
+				offset = start + 1
+				continue
+			}
+			lineStart = i + 1
+		}
+
+		rest := chunk[lineStart:]
+		if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+			return rest[:end], start + int64(lineStart+end+1), nil
+		}
+		if err == io.EOF {
+			if len(rest) == 0 {
+				return nil, 0, io.EOF
+			}
+			return rest, c.size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if lineStart == 0 && n == len(buf) {
+			return nil, 0, errors.New("breach list line too long")
+		}
+		start += int64(lineStart)
+		offset = 0
+	}
+}
+
+// BloomFilter is a compact, probabilistic set of breached hash digests.
+// False positives are possible at the configured rate; false negatives are
+// not.
+type BloomFilter struct {
+	format BreachHashFormat
+	k      uint32
+	bits   []uint64
+}
+
+var bloomMagic = [4]byte{'B', 'L', 'M', '1'}
+
+// NewBloomFilter sizes a filter for n entries at false positive rate fp.
+func NewBloomFilter(format BreachHashFormat, n uint64, fp float64) *BloomFilter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{
+		format: format,
+		k:      k,
+		bits:   make([]uint64, (m+63)/64),
+	}
+}
+
+func (f *BloomFilter) Add(digest []byte) {
+	h1, h2 := bloomHashes(digest)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *BloomFilter) Test(digest []byte) bool {
+	h1, h2 := bloomHashes(digest)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *BloomFilter) IsBreached(password string) (bool, error) {
+	return f.Test(f.format.Digest(password)), nil
+}
+
+// WriteTo stores the filter as magic, format, k, word count and the bit
+// array, all little endian.
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 0, 17)
+	header = append(header, bloomMagic[:]...)
+	header = append(header, byte(f.format))
+	header = binary.LittleEndian.AppendUint32(header, f.k)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(f.bits)))
+
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	buf := make([]byte, 0, 8*4096)
+	for i, word := range f.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, word)
+		if len(buf) == cap(buf) || i == len(f.bits)-1 {
+			n, err := w.Write(buf)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	return written, nil
+}
+
+func LoadBloomFilter(path string) (*BloomFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+	}
+
+	if len(data) < 17 || !bytes.Equal(data[:4], bloomMagic[:]) {
+		return nil, errors.New("invalid bloom filter file")
+	}
+
+	f := &BloomFilter{
+		format: BreachHashFormat(data[4]),
+		k:      binary.LittleEndian.Uint32(data[5:9]),
+	}
+	words := binary.LittleEndian.Uint64(data[9:17])
+	if f.k == 0 || words == 0 || uint64(len(data)-17) != words*8 {
+		return nil, errors.New("invalid bloom filter file")
+	}
+
+	f.bits = make([]uint64, words)
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(data[17+8*i:])
+	}
+
+	return f, nil
+}
+
+func bloomHashes(digest []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(digest)
+	sum := h.Sum(nil)
+	return binary.LittleEndian.Uint64(sum[:8]), binary.LittleEndian.Uint64(sum[8:]) | 1
+}
@@ -0,0 +1,55 @@
+package auth
+
+// Algorithm is a Hasher that can tell its own hashes apart from those of
+// other algorithms, so several of them can share the pwd_hash column.
+type Algorithm interface {
+	Hasher
+	Recognizes(hash string) bool
+}
+
+// MultiHasher hashes with the current algorithm and verifies against any of
+// the configured ones. Hashes not produced by the current algorithm with its
+// current parameters report NeedsRehash.
+type MultiHasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+}
+
+func NewMultiHasher(current Algorithm, legacy ...Algorithm) *MultiHasher {
+	return &MultiHasher{
+		current:    current,
+		algorithms: append([]Algorithm{current}, legacy...),
+	}
+}
+
+func (h *MultiHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *MultiHasher) Compare(hash, password string) bool {
+	algorithm := h.find(hash)
+	if algorithm == nil {
+		return false
+	}
+	return algorithm.Compare(hash, password)
+}
+
+func (h *MultiHasher) NeedsRehash(hash string) bool {
+	if !h.current.Recognizes(hash) {
+		return true
+	}
+	return h.current.NeedsRehash(hash)
+}
+
+func (h *MultiHasher) Recognizes(hash string) bool {
+	return h.find(hash) != nil
+}
+
+func (h *MultiHasher) find(hash string) Algorithm {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Recognizes(hash) {
+			return algorithm
+		}
+	}
+	return nil
+}
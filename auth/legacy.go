@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrLegacyAlgorithm is returned by algorithms that only verify imported
+// hashes; passwords are rehashed with the current algorithm on login.
+var ErrLegacyAlgorithm = errors.New("algorithm only supports verification")
+
+const (
+	pbkdf2SHA256Prefix   = "$pbkdf2-sha256$"
+	firebaseScryptPrefix = "$firebase-scrypt$"
+)
+
+// PBKDF2SHA256Hasher verifies hashes imported from Django's pbkdf2_sha256
+// hasher, stored as $pbkdf2-sha256$i=<iterations>$<salt>$<key>.
+type PBKDF2SHA256Hasher struct{}
+
+func NewPBKDF2SHA256Hasher() *PBKDF2SHA256Hasher {
+	return &PBKDF2SHA256Hasher{}
+}
+
+func (h *PBKDF2SHA256Hasher) Hash(password string) (string, error) {
+	return "", ErrLegacyAlgorithm
+}
+
+func (h *PBKDF2SHA256Hasher) Compare(hash, password string) bool {
+	params, salt, key, err := decodePHC(hash, "pbkdf2-sha256")
+	if err != nil {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(params["i"])
+	if err != nil || iterations < 1 {
+		return false
+	}
+
+	other, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h *PBKDF2SHA256Hasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+func (h *PBKDF2SHA256Hasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, pbkdf2SHA256Prefix)
+}
+
+// FirebaseScryptHasher verifies hashes exported from Firebase Authentication,
+// stored as $firebase-scrypt$ln=<mem cost>,r=<rounds>,p=1$<salt+separator>$<key>.
+// The project's signer key is needed to verify them.
+type FirebaseScryptHasher struct {
+	signerKey []byte
+}
+
+func NewFirebaseScryptHasher(signerKey []byte) *FirebaseScryptHasher {
+	return &FirebaseScryptHasher{signerKey: signerKey}
+}
+
+func (h *FirebaseScryptHasher) Hash(password string) (string, error) {
+	return "", ErrLegacyAlgorithm
+}
+
+func (h *FirebaseScryptHasher) Compare(hash, password string) bool {
+	params, salt, key, err := decodePHC(hash, "firebase-scrypt")
+	if err != nil {
+		return false
+	}
+
+	memCost, err1 := strconv.Atoi(params["ln"])
+	rounds, err2 := strconv.Atoi(params["r"])
+	parallelism, err3 := strconv.Atoi(params["p"])
+	if err1 != nil || err2 != nil || err3 != nil || memCost < 1 || memCost > 30 {
+		return false
+	}
+
+	derived, err := scrypt.Key([]byte(password), salt, 1<<memCost, rounds, parallelism, 32)
+	if err != nil {
+		return false
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return false
+	}
+
+	other := make([]byte, len(h.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(other, h.signerKey)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (h *FirebaseScryptHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+func (h *FirebaseScryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, firebaseScryptPrefix)
+}
+
+// PHPassHasher verifies phpass portable hashes ($P$, $H$) and md5-crypt
+// hashes ($1$) as produced by older PHP applications.
+type PHPassHasher struct{}
+
+func NewPHPassHasher() *PHPassHasher {
+	return &PHPassHasher{}
+}
+
+func (h *PHPassHasher) Hash(password string) (string, error) {
+	return "", ErrLegacyAlgorithm
+}
+
+func (h *PHPassHasher) Compare(hash, password string) bool {
+	var other string
+	switch {
+	case strings.HasPrefix(hash, "$1$"):
+		other = md5Crypt(password, hash)
+	default:
+		other = phpassPortable(password, hash)
+	}
+	return other != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(other)) == 1
+}
+
+func (h *PHPassHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+func (h *PHPassHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$P$") || strings.HasPrefix(hash, "$H$") || strings.HasPrefix(hash, "$1$")
+}
+
+// EncodePHC formats a hash as $<id>$<params>$<salt>$<key> with unpadded
+// standard base64, the layout shared by all algorithms in this package.
+func EncodePHC(id, params string, salt, key []byte) string {
+	return fmt.Sprintf(
+		"$%s$%s$%s$%s",
+		id,
+		params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodePHC(hash, id string) (map[string]string, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != id {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(parts[2], ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, nil, nil, ErrInvalidHash
+		}
+		params[name] = value
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func phpassPortable(password, setting string) string {
+	if len(setting) != 34 {
+		return ""
+	}
+
+	countLog2 := strings.IndexByte(itoa64, setting[3])
+	if countLog2 < 7 || countLog2 > 30 {
+		return ""
+	}
+
+	salt := setting[4:12]
+	sum := md5.Sum([]byte(salt + password))
+	for count := 1 << countLog2; count > 0; count-- {
+		sum = md5.Sum(append(sum[:], password...))
+	}
+
+	return setting[:12] + phpassEncode64(sum[:])
+}
+
+func phpassEncode64(input []byte) string {
+	var out strings.Builder
+	count := len(input)
+	for i := 0; i < count; {
+		value := int(input[i])
+		i++
+		out.WriteByte(itoa64[value&0x3f])
+		if i < count {
+			value |= int(input[i]) << 8
+		}
+		out.WriteByte(itoa64[(value>>6)&0x3f])
+		if i >= count {
+			break
+		}
+		i++
+		if i < count {
+			value |= int(input[i]) << 16
+		}
+		out.WriteByte(itoa64[(value>>12)&0x3f])
+		if i >= count {
+			break
+		}
+		i++
+		out.WriteByte(itoa64[(value>>18)&0x3f])
+	}
+	return out.String()
+}
+
+func md5Crypt(password, setting string) string {
+	const magic = "$1$"
+
+	salt := strings.TrimPrefix(setting, magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	pw := []byte(password)
+	alt := md5.Sum(bytes.Join([][]byte{pw, []byte(salt), pw}, nil))
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic + salt))
+	for n := len(pw); n > 0; n -= 16 {
+		ctx.Write(alt[:min(n, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+
+	return out.String()
+}
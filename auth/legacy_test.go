@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// Firebase's published scrypt sample, from github.com/firebase/scrypt.
+const (
+	firebaseSignerKey     = "jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA=="
+	firebaseSaltSeparator = "Bw=="
+	firebaseSalt          = "42xEC+ixf3L2lw=="
+	firebasePasswordHash  = "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ=="
+)
+
+func TestMD5Crypt(t *testing.T) {
+	// openssl passwd -1 -salt saltstri password
+	const want = "$1$saltstri$qQY4WxjABChYG1ccLpfkz/"
+	if got := md5Crypt("password", "$1$saltstri$"); got != want {
+		t.Errorf("md5Crypt = %q, want %q", got, want)
+	}
+}
+
+func TestPHPassHasher(t *testing.T) {
+	tests := []struct {
+		hash     string
+		password string
+	}{
+		// From the phpass test suite.
+		{"$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0", "test12345"},
+		{"$1$saltstri$qQY4WxjABChYG1ccLpfkz/", "password"},
+	}
+
+	h := NewPHPassHasher()
+	for _, tt := range tests {
+		if !h.Recognizes(tt.hash) {
+			t.Errorf("Recognizes(%q) = false", tt.hash)
+		}
+		if !h.Compare(tt.hash, tt.password) {
+			t.Errorf("Compare(%q, %q) = false", tt.hash, tt.password)
+		}
+		if h.Compare(tt.hash, tt.password+"x") {
+			t.Errorf("Compare(%q) accepted a wrong password", tt.hash)
+		}
+	}
+}
+
+func TestPBKDF2SHA256Hasher(t *testing.T) {
+	// hashlib.pbkdf2_hmac("sha256", b"letmein", b"seasalt", 1000)
+	const hash = "$pbkdf2-sha256$i=1000$c2Vhc2FsdA$R1/GfWtwog1T8Ev9VndgDjzkiRzbFr8JpmJtL9cMmQU"
+
+	h := NewPBKDF2SHA256Hasher()
+	if !h.Compare(hash, "letmein") {
+		t.Error("Compare rejected the right password")
+	}
+	if h.Compare(hash, "letmeout") {
+		t.Error("Compare accepted a wrong password")
+	}
+}
+
+func TestFirebaseScryptHasher(t *testing.T) {
+	signerKey := mustDecode(t, firebaseSignerKey)
+	salt := append(mustDecode(t, firebaseSalt), mustDecode(t, firebaseSaltSeparator)...)
+	hash := EncodePHC("firebase-scrypt", "ln=14,r=8,p=1", salt, mustDecode(t, firebasePasswordHash))
+
+	h := NewFirebaseScryptHasher(signerKey)
+	if !h.Recognizes(hash) {
+		t.Errorf("Recognizes(%q) = false", hash)
+	}
+	if !h.Compare(hash, "user1password") {
+		t.Error("Compare rejected the right password")
+	}
+	if h.Compare(hash, "user2password") {
+		t.Error("Compare accepted a wrong password")
+	}
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,45 @@
+package auth
+
+import "time"
+
+// LockoutPolicy slows down password guessing against a single account.
+// Each failed login doubles the wait before the next attempt, starting at
+// BaseDelay, and every Threshold failures lock the account for a lock
+// duration that doubles with each lock, starting at LockDuration.
+type LockoutPolicy struct {
+	Threshold       int // failures that lock the account, 0 disables locking
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockDuration    time.Duration
+	MaxLockDuration time.Duration
+}
+
+// Delay is the minimum wait after the last of failures failed logins.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	return doubled(p.BaseDelay, failures-1, p.MaxDelay)
+}
+
+// LockFor is how long the failure that brought the count to failures locks
+// the account, or zero if it does not.
+func (p LockoutPolicy) LockFor(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold || failures%p.Threshold != 0 {
+		return 0
+	}
+	return doubled(p.LockDuration, failures/p.Threshold-1, p.MaxLockDuration)
+}
+
+func doubled(d time.Duration, times int, limit time.Duration) time.Duration {
+	for ; times > 0; times-- {
+		if limit > 0 && d >= limit {
+			break
+		}
+		d *= 2
+	}
+	if limit > 0 && d > limit {
+		return limit
+	}
+	return d
+}
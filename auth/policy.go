@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type PasswordPolicy struct {
+	MinLength      int  `json:"min_length"`
+	MaxLength      int  `json:"max_length"`
+	RequireUpper   bool `json:"require_upper"`
+	RequireLower   bool `json:"require_lower"`
+	RequireDigit   bool `json:"require_digit"`
+	RequireSymbol  bool `json:"require_symbol"`
+	MinStrength    int  `json:"min_strength"` // 0-4, see EstimateStrength
+	RejectEmail    bool `json:"reject_email"`
+	RejectBreached bool `json:"reject_breached"`
+}
+
+// PolicyError describes why a password was rejected. Its message is safe to
+// show to the user; Key and Args identify it in the message catalogs.
+type PolicyError struct {
+	Key     string
+	Args    []any
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+func policyError(key, format string, args ...any) *PolicyError {
+	return &PolicyError{Key: key, Args: args, Message: fmt.Sprintf(format, args...)}
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      8,
+		MaxLength:      128,
+		MinStrength:    2,
+		RejectEmail:    true,
+		RejectBreached: true,
+	}
+}
+
+// Validate checks password against the policy. email is the account's
+// address and may be empty.
+func (p PasswordPolicy) Validate(password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return policyError("password.min_length", "Password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return policyError("password.max_length", "Password must be at most %d characters", p.MaxLength)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return policyError("password.require_upper", "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return policyError("password.require_lower", "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return policyError("password.require_digit", "Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return policyError("password.require_symbol", "Password must contain a symbol")
+	}
+
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if p.RejectEmail && len(localPart) >= 3 && strings.Contains(strings.ToLower(password), localPart) {
+		return policyError("password.contains_email", "Password must not contain your email address")
+	}
+
+	if p.MinStrength > 0 && EstimateStrength(password, localPart) < p.MinStrength {
+		return policyError("password.too_weak", "Password is too easy to guess")
+	}
+
+	return nil
+}
+
+// PasswordPolicies holds the default policy and per-client overrides.
+// Breached is optional; when set, policies with RejectBreached consult it.
+type PasswordPolicies struct {
+	Default  PasswordPolicy
+	Clients  map[string]PasswordPolicy
+	Breached BreachChecker
+}
+
+func NewPasswordPolicies(defaultPolicy PasswordPolicy) *PasswordPolicies {
+	return &PasswordPolicies{
+		Default: defaultPolicy,
+		Clients: make(map[string]PasswordPolicy),
+	}
+}
+
+// LoadClientOverrides reads a JSON object keyed by client_id. Fields missing
+// from an entry keep their default value.
+func (p *PasswordPolicies) LoadClientOverrides(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read password policy file: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse password policy file: %w", err)
+	}
+
+	for clientID, entry := range raw {
+		policy := p.Default
+		if err := json.Unmarshal(entry, &policy); err != nil {
+			return fmt.Errorf("failed to parse password policy for client %q: %w", clientID, err)
+		}
+		p.Clients[clientID] = policy
+	}
+
+	return nil
+}
+
+// For returns the policy for clientID, falling back to the default.
+func (p *PasswordPolicies) For(clientID string) PasswordPolicy {
+	if policy, ok := p.Clients[clientID]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Check validates password against the policy for clientID, including the
+// breach corpus. Rejections are returned as *PolicyError; any other error
+// means the check itself failed.
+func (p *PasswordPolicies) Check(clientID, password, email string) error {
+	policy := p.For(clientID)
+	if err := policy.Validate(password, email); err != nil {
+		return err
+	}
+
+	if policy.RejectBreached && p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			return policyError("password.breached", "This password has appeared in a data breach, please choose another")
+		}
+	}
+
+	return nil
+}
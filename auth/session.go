@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type Session struct {
+	TokenHash string
+	UserID    int
+	ClientID  string
+	ExpiresAt time.Time
+	ReauthAt  time.Time
+}
+
+type SessionManager struct {
+	generator TokenGenerator
+	ttl       time.Duration
+}
+
+func NewSessionManager(generator TokenGenerator, ttl time.Duration) *SessionManager {
+	return &SessionManager{
+		generator: generator,
+		ttl:       ttl,
+	}
+}
+
+func (m *SessionManager) CreateSession(userID int, clientID string) (*Session, string, error) {
+	token, err := m.generator.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &Session{
+		TokenHash: HashToken(token),
+		UserID:    userID,
+		ClientID:  clientID,
+		ExpiresAt: now.Add(m.ttl),
+		ReauthAt:  now,
+	}
+
+	return session, token, nil
+}
+
+func (m *SessionManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// HashToken returns a deterministic digest of a high-entropy token so it can
+// be looked up by value. It must not be used for passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
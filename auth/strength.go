@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords is ordered by frequency; a match costs its rank in guesses.
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234",
+	"111111", "1234567", "dragon", "123123", "baseball", "abc123", "football",
+	"monkey", "letmein", "696969", "shadow", "master", "666666", "qwertyuiop",
+	"123321", "mustang", "1234567890", "michael", "654321", "superman",
+	"1qaz2wsx", "7777777", "121212", "000000", "qazwsx", "123qwe", "killer",
+	"trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter", "buster",
+	"soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou",
+	"2000", "charlie", "robert", "thomas", "hockey", "ranger", "daniel",
+	"starwars", "klaster", "112233", "george", "computer", "michelle",
+	"jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313",
+	"freedom", "777777", "pass", "maggie", "159753", "aaaaaa", "ginger",
+	"princess", "joshua", "cheese", "amanda", "summer", "love", "ashley",
+	"nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321",
+	"dallas", "austin", "thunder", "taylor", "matrix", "welcome", "admin",
+	"login", "passw0rd", "secret", "hello", "dragon", "qwerty123", "football1",
+	"monkey1", "winter", "spring", "autumn", "changeme", "default", "guest",
+}
+
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik,9ol.0p;/", "qazwsxedcrfvtgbyhnujmikolp",
+}
+
+var leetSubstitutions = strings.NewReplacer(
+	"4", "a", "@", "a", "8", "b", "3", "e", "6", "g", "1", "i", "!", "i",
+	"0", "o", "5", "s", "$", "s", "7", "t", "+", "t", "2", "z",
+)
+
+// EstimateStrength returns a zxcvbn-style score from 0 (trivially guessable)
+// to 4 (very unguessable). The password is split greedily into the cheapest
+// recognisable patterns (common passwords, words from userInputs, repeats,
+// sequences, recent years and keyboard runs), each costing a number of
+// guesses; anything left over is brute-forced per character.
+func EstimateStrength(password string, userInputs ...string) int {
+	guesses := math.Log10(estimateGuesses(password, userInputs))
+
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func estimateGuesses(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	unleet := []rune(leetSubstitutions.Replace(string(lower)))
+	if len(unleet) != len(lower) {
+		unleet = lower
+	}
+
+	dictionary := make(map[string]float64, len(commonPasswords)+len(userInputs))
+	for i, word := range commonPasswords {
+		if _, ok := dictionary[word]; !ok {
+			dictionary[word] = float64(i + 1)
+		}
+	}
+	for _, input := range userInputs {
+		if input = strings.ToLower(input); len(input) >= 3 {
+			dictionary[input] = 1
+		}
+	}
+
+	logGuesses := 0.0
+	patterns := 0
+	for i := 0; i < len(runes); {
+		length, cost := matchPattern(lower, unleet, i, dictionary)
+		if length == 0 {
+			length, cost = 1, float64(charCardinality(runes[i]))
+		} else {
+			patterns++
+		}
+		logGuesses += math.Log10(cost)
+		i += length
+	}
+
+	// Each extra pattern boundary has to be guessed as well.
+	logGuesses += log10Factorial(patterns)
+
+	return math.Pow(10, logGuesses)
+}
+
+func matchPattern(lower, unleet []rune, i int, dictionary map[string]float64) (int, float64) {
+	bestLen, bestCost := 0, 0.0
+	consider := func(length int, cost float64) {
+		if length > bestLen || (length == bestLen && cost < bestCost) {
+			bestLen, bestCost = length, cost
+		}
+	}
+
+	for j := len(lower); j >= i+3; j-- {
+		if rank, ok := dictionary[string(lower[i:j])]; ok {
+			consider(j-i, rank)
+		}
+		if rank, ok := dictionary[string(unleet[i:j])]; ok {
+			consider(j-i, rank*2)
+		}
+	}
+
+	if n := runLength(lower, i, func(a, b rune) bool { return a == b }); n >= 3 {
+		consider(n, float64(charCardinality(lower[i])*n))
+	}
+
+	if n := runLength(lower, i, func(a, b rune) bool { return b == a+1 }); n >= 3 {
+		consider(n, float64(4*n))
+	}
+	if n := runLength(lower, i, func(a, b rune) bool { return b == a-1 }); n >= 3 {
+		consider(n, float64(8*n))
+	}
+
+	if i+4 <= len(lower) {
+		if year := string(lower[i : i+4]); (year >= "1900" && year <= "2039") && isDigits(year) {
+			consider(4, 120)
+		}
+	}
+
+	for _, row := range keyboardRows {
+		for j := len(lower); j >= i+4; j-- {
+			chunk := string(lower[i:j])
+			if strings.Contains(row, chunk) || strings.Contains(reverse(row), chunk) {
+				consider(j-i, float64(10*(j-i)))
+				break
+			}
+		}
+	}
+
+	// Only take a pattern when it is cheaper than brute-forcing its characters.
+	if bestLen > 0 {
+		bruteforce := 1.0
+		for _, r := range lower[i : i+bestLen] {
+			bruteforce *= float64(charCardinality(r))
+		}
+		if bestCost >= bruteforce {
+			return 0, 0
+		}
+	}
+
+	return bestLen, bestCost
+}
+
+func runLength(runes []rune, i int, next func(a, b rune) bool) int {
+	n := 1
+	for i+n < len(runes) && next(runes[i+n-1], runes[i+n]) {
+		n++
+	}
+	return n
+}
+
+func charCardinality(r rune) int {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case r < unicode.MaxASCII && unicode.IsLetter(r):
+		return 26
+	case r < unicode.MaxASCII:
+		return 33
+	default:
+		return 100
+	}
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// log10Factorial returns log10(n!) without overflowing for long passwords.
+func log10Factorial(n int) float64 {
+	lgamma, _ := math.Lgamma(float64(n) + 1)
+	return lgamma / math.Ln10
+}
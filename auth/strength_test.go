@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		want       int
+	}{
+		{"password", nil, 0},
+		{"123456", nil, 0},
+		{"qwerty123", nil, 0},
+		{"P@ssw0rd", nil, 0},
+		{"aaaaaaaa", nil, 0},
+		{"abcdefgh", nil, 0},
+		{"jane.doe", []string{"jane.doe"}, 0},
+		{"zxcvbnm2019", nil, 1},
+		{"x7#Kq9!mZ2$vLp", nil, 4},
+		{"correct horse battery staple", nil, 4},
+	}
+	for _, tt := range tests {
+		if got := EstimateStrength(tt.password, tt.userInputs...); got != tt.want {
+			t.Errorf("EstimateStrength(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestLog10Factorial(t *testing.T) {
+	tests := []struct {
+		n    int
+		want float64
+	}{
+		{0, 0},
+		{1, 0},
+		{5, math.Log10(120)},
+		{20, math.Log10(2432902008176640000)},
+		// 25! no longer fits in an int64.
+		{25, 25.19064568},
+	}
+	for _, tt := range tests {
+		if got := log10Factorial(tt.n); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("log10Factorial(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// Branding customizes the login pages and emails shown for a client. All
+// fields are optional.
+type Branding struct {
+	DisplayName     string
+	LogoURL         string
+	PrimaryColor    string // buttons and links
+	BackgroundColor string
+	CSS             template.CSS // appended after the default styles
+}
+
+type Client struct {
+	ID       string
+	Branding Branding
+	// TemplateDir optionally overrides page templates (web/*.html) with
+	// files of the same name, and email templates with files in its
+	// email/ subdirectory.
+	TemplateDir string
+	// AllowedOrigins may call the API cross-origin for this client, e.g.
+	// "https://app.example.com".
+	AllowedOrigins []string
+}
+
+// Registry holds the registered clients. Unknown client IDs get the
+// default, unbranded look.
+type Registry struct {
+	clients map[string]*Client
+}
+
+type clientFile struct {
+	DisplayName     string   `json:"display_name"`
+	LogoURL         string   `json:"logo_url"`
+	PrimaryColor    string   `json:"primary_color"`
+	BackgroundColor string   `json:"background_color"`
+	CSSFile         string   `json:"css_file"`
+	TemplateDir     string   `json:"template_dir"`
+	AllowedOrigins  []string `json:"allowed_origins"`
+}
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func NewRegistry() *Registry {
+	return &Registry{clients: make(map[string]*Client)}
+}
+
+// Load reads a JSON object keyed by client_id. Relative css_file and
+// template_dir paths are resolved against the file's directory.
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clients file: %w", err)
+	}
+
+	var raw map[string]clientFile
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse clients file: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	r := NewRegistry()
+	for id, entry := range raw {
+		for _, color := range []string{entry.PrimaryColor, entry.BackgroundColor} {
+			if color != "" && !colorPattern.MatchString(color) {
+				return nil, fmt.Errorf("client %q: color %q must be a #rgb or #rrggbb hex value", id, color)
+			}
+		}
+
+		for _, origin := range entry.AllowedOrigins {
+			if !isOrigin(origin) {
+				return nil, fmt.Errorf("client %q: allowed origin %q must be scheme://host[:port]", id, origin)
+			}
+		}
+
+		client := &Client{
+			ID: id,
+			Branding: Branding{
+				DisplayName:     entry.DisplayName,
+				LogoURL:         entry.LogoURL,
+				PrimaryColor:    entry.PrimaryColor,
+				BackgroundColor: entry.BackgroundColor,
+			},
+			TemplateDir:    resolve(entry.TemplateDir),
+			AllowedOrigins: entry.AllowedOrigins,
+		}
+
+		if entry.CSSFile != "" {
+			css, err := os.ReadFile(resolve(entry.CSSFile))
+			if err != nil {
+				return nil, fmt.Errorf("client %q: failed to read css file: %w", id, err)
+			}
+			// The file comes from the operator's configuration, so it
+			// is trusted not to break out of the style element.
+			client.Branding.CSS = template.CSS(css)
+		}
+
+		r.clients[id] = client
+	}
+
+	return r, nil
+}
+
+// Get returns the registered client, or an unbranded one for unknown IDs.
+func (r *Registry) Get(clientID string) *Client {
+	if client, ok := r.clients[clientID]; ok {
+		return client
+	}
+	return &Client{ID: clientID}
+}
+
+func (r *Registry) All() []*Client {
+	all := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		all = append(all, client)
+	}
+	return all
+}
+
+// AllowsOrigin reports whether origin may make cross-origin requests for
+// clientID, or for any client when clientID is empty.
+func (r *Registry) AllowsOrigin(clientID, origin string) bool {
+	if clientID != "" {
+		client, ok := r.clients[clientID]
+		return ok && slices.Contains(client.AllowedOrigins, origin)
+	}
+	return slices.Contains(r.Origins(), origin)
+}
+
+// Origins returns the allowed origins of all clients.
+func (r *Registry) Origins() []string {
+	var origins []string
+	for _, client := range r.clients {
+		origins = append(origins, client.AllowedOrigins...)
+	}
+	return origins
+}
+
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" &&
+		u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == ""
+}
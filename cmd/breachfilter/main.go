@@ -0,0 +1,108 @@
+// Command breachfilter builds a bloom filter from a downloaded HIBP
+// "ordered by hash" password corpus for use with PASSWORD_BREACH_BLOOM_FILE.
+//
+//	go run ./cmd/breachfilter -in pwned-passwords-sha1-ordered-by-hash.txt -out breached.bloom
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/yookibooki/auth/auth"
+)
+
+func main() {
+	in := flag.String("in", "", "HIBP hash list, one <HASH>:<COUNT> per line")
+	out := flag.String("out", "breached.bloom", "output filter file")
+	formatName := flag.String("format", "sha1", "hash format of the list: sha1 or ntlm")
+	fp := flag.Float64("fp", 0.001, "target false positive rate")
+	minCount := flag.Int("min-count", 1, "skip hashes seen fewer times than this")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	format, err := auth.ParseBreachHashFormat(*formatName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := countEntries(*in, *minCount)
+	if err != nil {
+		log.Fatalf("Failed to read corpus: %v", err)
+	}
+	if n == 0 {
+		log.Fatal("Corpus contains no entries")
+	}
+
+	filter := auth.NewBloomFilter(format, n, *fp)
+	err = eachEntry(*in, *minCount, func(digest []byte) {
+		filter.Add(digest)
+	})
+	if err != nil {
+		log.Fatalf("Failed to read corpus: %v", err)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Failed to create filter: %v", err)
+	}
+
+	w := bufio.NewWriter(f)
+	size, err := filter.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Failed to write filter: %v", err)
+	}
+
+	fmt.Printf("Wrote %d entries to %s (%d bytes)\n", n, *out, size)
+}
+
+func countEntries(path string, minCount int) (uint64, error) {
+	var n uint64
+	err := eachEntry(path, minCount, func([]byte) { n++ })
+	return n, err
+}
+
+func eachEntry(path string, minCount int, fn func(digest []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, count, _ := bytes.Cut(bytes.TrimSpace(scanner.Bytes()), []byte(":"))
+		if len(hash) == 0 {
+			continue
+		}
+
+		if minCount > 1 {
+			var c int
+			if _, err := fmt.Sscanf(string(count), "%d", &c); err == nil && c < minCount {
+				continue
+			}
+		}
+
+		digest, err := hex.DecodeString(string(hash))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		fn(digest)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,104 @@
+// Command forcepwchange flags accounts so that their owners must pick a new
+// password at the next login, e.g. after an incident. Addresses are given
+// as arguments or read from a file, one per line.
+//
+//	go run ./cmd/forcepwchange ada@example.com bob@example.com
+//	go run ./cmd/forcepwchange -in emails.txt -logout
+//	go run ./cmd/forcepwchange -undo ada@example.com
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/yookibooki/auth/config"
+	"github.com/yookibooki/auth/db"
+	"github.com/yookibooki/auth/repo"
+)
+
+func main() {
+	in := flag.String("in", "", "file of email addresses, one per line")
+	logout := flag.Bool("logout", false, "also end the accounts' sessions")
+	undo := flag.Bool("undo", false, "clear the flag instead of setting it")
+	flag.Parse()
+
+	emails := flag.Args()
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("Failed to open address list: %v", err)
+		}
+		listed, err := readEmails(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Failed to read address list: %v", err)
+		}
+		emails = append(emails, listed...)
+	}
+	if len(emails) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dbCfg := config.LoadDBConfig()
+	if dbCfg.Password == "" {
+		log.Fatal("DB_PASSWORD is required")
+	}
+
+	database, err := db.Open(&config.Config{DB: dbCfg})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close(database)
+
+	userRepo := repo.NewUserRepo(database)
+	sessionRepo := repo.NewSessionRepo(database)
+	ctx := context.Background()
+
+	updated, skipped := 0, 0
+	for _, email := range emails {
+		user, err := userRepo.FindByEmail(ctx, email)
+		if errors.Is(err, repo.ErrNotFound) {
+			log.Printf("Skipping %s: no such account", email)
+			skipped++
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to look up %s after %d users: %v", email, updated, err)
+		}
+
+		if err := userRepo.SetMustChangePassword(ctx, user.ID, !*undo); err != nil {
+			log.Fatalf("Failed to update %s after %d users: %v", email, updated, err)
+		}
+		if *logout {
+			if err := sessionRepo.DeleteByUserID(ctx, user.ID); err != nil {
+				log.Fatalf("Failed to end sessions of %s after %d users: %v", email, updated, err)
+			}
+		}
+		updated++
+	}
+
+	fmt.Printf("Updated %d users, skipped %d\n", updated, skipped)
+}
+
+// readEmails returns the addresses in r, one per line, ignoring blank lines
+// and lines starting with #.
+func readEmails(r io.Reader) ([]string, error) {
+	var emails []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		emails = append(emails, line)
+	}
+	return emails, scanner.Err()
+}
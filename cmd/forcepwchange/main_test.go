@@ -0,0 +1,19 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadEmails(t *testing.T) {
+	in := "# incident 42\nada@example.com\n\n  bob@example.com  \r\n"
+
+	got, err := readEmails(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ada@example.com", "bob@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("readEmails = %q, want %q", got, want)
+	}
+}
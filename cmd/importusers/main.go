@@ -0,0 +1,210 @@
+// Command importusers creates accounts from another system's user export,
+// converting its password hashes to the PHC strings understood by the
+// legacy verifiers in package auth. Imported passwords are rehashed with the
+// current algorithm on each user's first successful login.
+//
+//	go run ./cmd/importusers -source django -in users.csv
+//	go run ./cmd/importusers -source firebase -in users.json -firebase-salt-separator Bw== -firebase-rounds 8 -firebase-mem-cost 14
+//	go run ./cmd/importusers -source phpass -in users.csv
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/config"
+	"github.com/yookibooki/auth/db"
+	"github.com/yookibooki/auth/repo"
+)
+
+type importedUser struct {
+	Email   string
+	PwdHash string
+}
+
+type firebaseOptions struct {
+	saltSeparator string
+	rounds        int
+	memCost       int
+}
+
+func main() {
+	source := flag.String("source", "", "export format: django, firebase or phpass")
+	in := flag.String("in", "", "export file (CSV email,hash for django/phpass; auth:export JSON for firebase)")
+	dryRun := flag.Bool("dry-run", false, "convert and report without writing to the database")
+	var fb firebaseOptions
+	flag.StringVar(&fb.saltSeparator, "firebase-salt-separator", "", "base64 salt separator from the Firebase hash config")
+	flag.IntVar(&fb.rounds, "firebase-rounds", 8, "rounds from the Firebase hash config")
+	flag.IntVar(&fb.memCost, "firebase-mem-cost", 14, "mem_cost from the Firebase hash config")
+	flag.Parse()
+
+	if *source == "" || *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("Failed to open export: %v", err)
+	}
+	defer f.Close()
+
+	var users []importedUser
+	switch *source {
+	case "django":
+		users, err = readCSV(f, djangoToPHC)
+	case "phpass":
+		users, err = readCSV(f, phpassToPHC)
+	case "firebase":
+		users, err = readFirebase(f, fb)
+	default:
+		err = fmt.Errorf("unknown source %q", *source)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read export: %v", err)
+	}
+
+	if *dryRun {
+		fmt.Printf("Converted %d users\n", len(users))
+		return
+	}
+
+	dbCfg := config.LoadDBConfig()
+	if dbCfg.Password == "" {
+		log.Fatal("DB_PASSWORD is required")
+	}
+
+	database, err := db.Open(&config.Config{DB: dbCfg})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close(database)
+
+	userRepo := repo.NewUserRepo(database)
+	ctx := context.Background()
+
+	imported, skipped := 0, 0
+	for _, user := range users {
+		_, err := userRepo.Create(ctx, user.Email, user.PwdHash, "")
+		if errors.Is(err, repo.ErrConflict) {
+			log.Printf("Skipping %s: account already exists", user.Email)
+			skipped++
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to import %s after %d users: %v", user.Email, imported, err)
+		}
+		imported++
+	}
+
+	fmt.Printf("Imported %d users, skipped %d\n", imported, skipped)
+}
+
+func readCSV(r io.Reader, convert func(string) (string, error)) ([]importedUser, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	var users []importedUser
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		email := strings.TrimSpace(record[0])
+		if line == 1 && strings.EqualFold(email, "email") {
+			continue
+		}
+
+		pwdHash, err := convert(strings.TrimSpace(record[1]))
+		if err != nil {
+			log.Printf("Skipping %s (line %d): %v", email, line, err)
+			continue
+		}
+		users = append(users, importedUser{Email: email, PwdHash: pwdHash})
+	}
+
+	return users, nil
+}
+
+// djangoToPHC converts pbkdf2_sha256$<iterations>$<salt>$<base64 key>.
+func djangoToPHC(hash string) (string, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return "", errors.New("unsupported Django hash, only pbkdf2_sha256 is supported")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", fmt.Errorf("invalid key: %w", err)
+	}
+
+	return auth.EncodePHC("pbkdf2-sha256", "i="+parts[1], []byte(parts[2]), key), nil
+}
+
+// phpassToPHC accepts phpass portable ($P$, $H$) and md5-crypt ($1$) hashes,
+// which are already self-describing.
+func phpassToPHC(hash string) (string, error) {
+	if !auth.NewPHPassHasher().Recognizes(hash) {
+		return "", errors.New("unsupported PHP hash, expected $P$, $H$ or $1$")
+	}
+	return hash, nil
+}
+
+func readFirebase(r io.Reader, opts firebaseOptions) ([]importedUser, error) {
+	var export struct {
+		Users []struct {
+			Email        string `json:"email"`
+			PasswordHash string `json:"passwordHash"`
+			Salt         string `json:"salt"`
+		} `json:"users"`
+	}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	separator, err := base64.StdEncoding.DecodeString(opts.saltSeparator)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt separator: %w", err)
+	}
+
+	params := fmt.Sprintf("ln=%d,r=%d,p=1", opts.memCost, opts.rounds)
+
+	var users []importedUser
+	for _, u := range export.Users {
+		if u.Email == "" || u.PasswordHash == "" {
+			log.Printf("Skipping user without email or password: %q", u.Email)
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(u.PasswordHash)
+		if err != nil {
+			log.Printf("Skipping %s: invalid passwordHash: %v", u.Email, err)
+			continue
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(u.Salt)
+		if err != nil {
+			log.Printf("Skipping %s: invalid salt: %v", u.Email, err)
+			continue
+		}
+
+		pwdHash := auth.EncodePHC("firebase-scrypt", params, append(salt, separator...), key)
+		users = append(users, importedUser{Email: u.Email, PwdHash: pwdHash})
+	}
+
+	return users, nil
+}
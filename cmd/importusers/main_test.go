@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/yookibooki/auth/auth"
+)
+
+func TestDjangoToPHC(t *testing.T) {
+	hash, err := djangoToPHC("pbkdf2_sha256$1000$seasalt$R1/GfWtwog1T8Ev9VndgDjzkiRzbFr8JpmJtL9cMmQU=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "$pbkdf2-sha256$i=1000$c2Vhc2FsdA$R1/GfWtwog1T8Ev9VndgDjzkiRzbFr8JpmJtL9cMmQU"
+	if hash != want {
+		t.Errorf("djangoToPHC = %q, want %q", hash, want)
+	}
+	if !auth.NewPBKDF2SHA256Hasher().Compare(hash, "letmein") {
+		t.Error("converted hash does not verify")
+	}
+
+	for _, bad := range []string{"bcrypt_sha256$$2b$12$abc", "pbkdf2_sha256$1000$salt", "pbkdf2_sha256$1000$salt$!!"} {
+		if _, err := djangoToPHC(bad); err == nil {
+			t.Errorf("djangoToPHC(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPHPassToPHC(t *testing.T) {
+	for _, hash := range []string{"$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0", "$1$saltstri$qQY4WxjABChYG1ccLpfkz/"} {
+		got, err := phpassToPHC(hash)
+		if err != nil || got != hash {
+			t.Errorf("phpassToPHC(%q) = %q, %v", hash, got, err)
+		}
+	}
+	if _, err := phpassToPHC("$2y$10$abcdefghijklmnopqrstuu"); err == nil {
+		t.Error("phpassToPHC accepted a bcrypt hash")
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	in := "email,hash\n" +
+		" ada@example.com , $P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0\n" +
+		"bob@example.com,$2y$10$abcdefghijklmnopqrstuu\n"
+
+	users, err := readCSV(strings.NewReader(in), phpassToPHC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Email != "ada@example.com" || users[0].PwdHash != "$P$9IQRaTwmfeRo7ud9Fh4E2PdI0S3r.L0" {
+		t.Errorf("readCSV = %+v", users)
+	}
+}
+
+func TestReadFirebase(t *testing.T) {
+	// Firebase's published scrypt sample, from github.com/firebase/scrypt.
+	in := `{"users": [
+		{"email": "user1@example.com", "passwordHash": "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==", "salt": "42xEC+ixf3L2lw=="},
+		{"email": "nopassword@example.com"}
+	]}`
+
+	users, err := readFirebase(strings.NewReader(in), firebaseOptions{saltSeparator: "Bw==", rounds: 8, memCost: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Email != "user1@example.com" {
+		t.Fatalf("readFirebase = %+v", users)
+	}
+
+	signerKey, _ := base64.StdEncoding.DecodeString("jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==")
+	if !auth.NewFirebaseScryptHasher(signerKey).Compare(users[0].PwdHash, "user1password") {
+		t.Error("converted hash does not verify")
+	}
+}
@@ -2,20 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/clients"
 	"github.com/yookibooki/auth/config"
 	"github.com/yookibooki/auth/db"
 	"github.com/yookibooki/auth/email"
 	"github.com/yookibooki/auth/handlers"
+	"github.com/yookibooki/auth/i18n"
 	"github.com/yookibooki/auth/middleware"
+	"github.com/yookibooki/auth/pow"
+	"github.com/yookibooki/auth/ratelimit"
 	"github.com/yookibooki/auth/repo"
 	"github.com/yookibooki/auth/web"
 )
@@ -33,23 +41,212 @@ func main() {
 	defer db.Close(database)
 
 	tmpls := web.Parse()
+	emailTmpls := email.Parse()
+	locales := i18n.Load(cfg.I18n.DefaultLocale)
+
+	clientRegistry := clients.NewRegistry()
+	if cfg.Clients.File != "" {
+		clientRegistry, err = clients.Load(cfg.Clients.File)
+		if err != nil {
+			log.Fatalf("Failed to load clients: %v", err)
+		}
+	}
+	for _, client := range clientRegistry.All() {
+		if client.TemplateDir == "" {
+			continue
+		}
+		if err := tmpls.AddClientOverrides(client.ID, client.TemplateDir); err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+		if err := emailTmpls.AddClientOverrides(client.ID, filepath.Join(client.TemplateDir, "email")); err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+	}
 
 	userRepo := repo.NewUserRepo(database)
 	authCodeRepo := repo.NewAuthCodeRepo(database)
 	pwdResetRepo := repo.NewPwdResetTokenRepo(database)
+	signupTokenRepo := repo.NewSignupTokenRepo(database)
+	emailChangeRepo := repo.NewEmailChangeTokenRepo(database)
+	sessionRepo := repo.NewSessionRepo(database)
+	pwdHistoryRepo := repo.NewPwdHistoryRepo(database)
+	outboxRepo := repo.NewOutboxRepo(database)
+	suppressionRepo := repo.NewSuppressionRepo(database)
+	txManager := repo.NewTxManager(database)
+
+	argon2Params := auth.DefaultArgon2idParams()
+	argon2Params.Memory = uint32(cfg.Password.Argon2Memory)
+	argon2Params.Iterations = uint32(cfg.Password.Argon2Iterations)
+	argon2Params.Parallelism = uint8(cfg.Password.Argon2Parallelism)
+
+	pepper, err := auth.LoadPepper(cfg.Password.Peppers, cfg.Password.PepperFile, cfg.Password.PepperVersion)
+	if err != nil {
+		log.Fatalf("Failed to load password pepper: %v", err)
+	}
+
+	bcryptHasher := auth.NewPasswordHasher()
+	legacyHashers := []auth.Algorithm{
+		bcryptHasher,
+		auth.NewPBKDF2SHA256Hasher(),
+		auth.NewPHPassHasher(),
+	}
+	if cfg.Password.FirebaseSignerKey != "" {
+		signerKey, err := base64.StdEncoding.DecodeString(cfg.Password.FirebaseSignerKey)
+		if err != nil {
+			log.Fatalf("Invalid FIREBASE_SIGNER_KEY: %v", err)
+		}
+		legacyHashers = append(legacyHashers, auth.NewFirebaseScryptHasher(signerKey))
+	}
+	pwdHasher := auth.NewMultiHasher(auth.NewArgon2idHasher(argon2Params, pepper), legacyHashers...)
+	pwdPolicies := auth.NewPasswordPolicies(auth.PasswordPolicy{
+		MinLength:      cfg.Password.MinLength,
+		MaxLength:      cfg.Password.MaxLength,
+		RequireUpper:   cfg.Password.RequireUpper,
+		RequireLower:   cfg.Password.RequireLower,
+		RequireDigit:   cfg.Password.RequireDigit,
+		RequireSymbol:  cfg.Password.RequireSymbol,
+		MinStrength:    cfg.Password.MinStrength,
+		RejectEmail:    true,
+		RejectBreached: true,
+	})
+	if cfg.Password.PolicyFile != "" {
+		if err := pwdPolicies.LoadClientOverrides(cfg.Password.PolicyFile); err != nil {
+			log.Fatalf("Failed to load password policies: %v", err)
+		}
+	}
+
+	switch {
+	case cfg.Password.BreachBloomFile != "":
+		filter, err := auth.LoadBloomFilter(cfg.Password.BreachBloomFile)
+		if err != nil {
+			log.Fatalf("Failed to load breached password filter: %v", err)
+		}
+		pwdPolicies.Breached = filter
+	case cfg.Password.BreachListFile != "":
+		format, err := auth.ParseBreachHashFormat(cfg.Password.BreachListFormat)
+		if err != nil {
+			log.Fatalf("Failed to load breached password list: %v", err)
+		}
+		list, err := auth.OpenHashListChecker(cfg.Password.BreachListFile, format)
+		if err != nil {
+			log.Fatalf("Failed to load breached password list: %v", err)
+		}
+		defer list.Close()
+		pwdPolicies.Breached = list
+	}
 
-	pwdHasher := auth.NewPasswordHasher()
 	tokenGenerator := auth.NewSecureTokenGenerator(32)
 	emailValidator := auth.NewEmailValidator()
-	authCodeMgr := auth.NewAuthCodeManager(pwdHasher, tokenGenerator, 15*time.Minute)
-
-	emailSender := email.NewSMTPSender(
-		cfg.SMTP.Host,
-		cfg.SMTP.Port,
-		cfg.SMTP.User,
-		cfg.SMTP.Password,
-		cfg.SMTP.From,
-	)
+	authCodeMgr := auth.NewAuthCodeManager(tokenGenerator, 15*time.Minute)
+	sessionMgr := auth.NewSessionManager(tokenGenerator, cfg.Session.TTL)
+
+	lockoutPolicy := auth.LockoutPolicy{
+		Threshold:       cfg.Lockout.Threshold,
+		BaseDelay:       cfg.Lockout.BaseDelay,
+		MaxDelay:        cfg.Lockout.MaxDelay,
+		LockDuration:    cfg.Lockout.Duration,
+		MaxLockDuration: cfg.Lockout.MaxDuration,
+	}
+
+	var emailSender email.Sender
+	var devSender *email.DevSender
+	switch cfg.Email.Driver {
+	case "dev":
+		devSender, err = email.NewDevSender(cfg.SMTP.From, cfg.Email.DevDir)
+		if err != nil {
+			log.Fatalf("Failed to create dev mail sink: %v", err)
+		}
+		emailSender = devSender
+		log.Println("EMAIL_DRIVER=dev: emails are not delivered, browse them at /_dev/mail")
+	default:
+		var dkim *email.DKIMSigner
+		if cfg.SMTP.DKIMKeyFile != "" {
+			dkim, err = email.LoadDKIMSigner(cfg.SMTP.DKIMDomain, cfg.SMTP.DKIMSelector, cfg.SMTP.DKIMKeyFile)
+			if err != nil {
+				log.Fatalf("Failed to load DKIM key: %v", err)
+			}
+		}
+
+		smtpSender, err := email.NewSMTPSender(email.SMTPOptions{
+			Host:           cfg.SMTP.Host,
+			Port:           cfg.SMTP.Port,
+			Username:       cfg.SMTP.User,
+			Password:       cfg.SMTP.Password,
+			From:           cfg.SMTP.From,
+			TLSMode:        cfg.SMTP.TLSMode,
+			CAFile:         cfg.SMTP.CAFile,
+			ServerName:     cfg.SMTP.ServerName,
+			AuthMethod:     cfg.SMTP.AuthMethod,
+			PoolSize:       cfg.SMTP.PoolSize,
+			KeepAlive:      cfg.SMTP.KeepAlive,
+			IdleTimeout:    cfg.SMTP.IdleTimeout,
+			CommandTimeout: cfg.SMTP.CommandTimeout,
+			DKIM:           dkim,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create SMTP sender: %v", err)
+		}
+		defer smtpSender.Close()
+		emailSender = smtpSender
+	}
+
+	emailSender = email.NewSuppressingSender(emailSender, suppressionRepo)
+
+	outboxWorker := email.NewWorker(outboxRepo, emailSender, cfg.Outbox.MaxAttempts, cfg.Outbox.PollInterval)
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		outboxWorker.Run(workerCtx)
+	}()
+
+	if cfg.Email.BounceMaildir != "" {
+		bounceProcessor := email.NewBounceProcessor(cfg.Email.BounceMaildir, suppressionRepo, cfg.Email.BouncePollInterval)
+		go bounceProcessor.Run(workerCtx)
+	}
+
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Backend == "postgres" {
+		rateLimitRepo := repo.NewRateLimitRepo(database)
+		rateLimitStore = rateLimitRepo
+		go func() {
+			ticker := time.NewTicker(10 * time.Minute)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-workerCtx.Done():
+					return
+				case <-ticker.C:
+					if err := rateLimitRepo.CleanupExpired(workerCtx); err != nil {
+						log.Printf("Failed to clean up rate limits: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	var puzzle *pow.Puzzle
+	if cfg.PoW.Enabled {
+		key, err := base64.StdEncoding.DecodeString(cfg.PoW.Secret)
+		if err != nil {
+			log.Fatalf("Failed to decode POW_SECRET: %v", err)
+		}
+		if len(key) == 0 {
+			// Challenges issued by one instance cannot be verified by
+			// another, which is fine for a single instance.
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				log.Fatalf("Failed to generate proof of work key: %v", err)
+			}
+		}
+		puzzle = pow.New(key, rateLimitStore, pow.Options{
+			Difficulty:     cfg.PoW.Difficulty,
+			MaxDifficulty:  cfg.PoW.MaxDifficulty,
+			TTL:            cfg.PoW.TTL,
+			AbuseWindow:    cfg.PoW.AbuseWindow,
+			AbuseThreshold: cfg.PoW.AbuseThreshold,
+		})
+	}
 
 	baseURL := fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
 
@@ -57,10 +254,24 @@ func main() {
 		tmpls,
 		userRepo,
 		authCodeRepo,
+		sessionRepo,
+		pwdResetRepo,
+		signupTokenRepo,
 		pwdHasher,
+		pwdPolicies,
+		pwdHistoryRepo,
+		cfg.Password.HistorySize,
+		cfg.Password.MaxAge,
+		lockoutPolicy,
+		cfg.Auth.EnumerationSafe,
 		authCodeMgr,
+		sessionMgr,
 		emailValidator,
-		emailSender,
+		txManager,
+		emailTmpls,
+		locales,
+		clientRegistry,
+		puzzle,
 		baseURL,
 	)
 
@@ -69,40 +280,173 @@ func main() {
 		userRepo,
 		pwdResetRepo,
 		pwdHasher,
+		pwdPolicies,
+		pwdHistoryRepo,
+		cfg.Password.HistorySize,
+		cfg.Auth.EnumerationSafe,
 		authCodeMgr,
-		emailSender,
+		txManager,
+		emailTmpls,
+		locales,
+		clientRegistry,
+		puzzle,
 		baseURL,
 	)
 
 	accountHandlers := handlers.NewAccountHandlers(
 		tmpls,
 		userRepo,
+		sessionRepo,
+		emailChangeRepo,
+		txManager,
 		pwdHasher,
+		pwdPolicies,
+		pwdHistoryRepo,
+		cfg.Password.HistorySize,
+		lockoutPolicy,
+		cfg.Auth.EnumerationSafe,
+		authCodeMgr,
+		emailTmpls,
 		emailValidator,
+		suppressionRepo,
+		locales,
+		baseURL,
+	)
+
+	// Validated by config.Load.
+	rateLimitAlgorithm, _ := ratelimit.ParseAlgorithm(cfg.RateLimit.Algorithm)
+	ipLimit := ratelimit.Limit{Algorithm: rateLimitAlgorithm, Requests: cfg.RateLimit.IPRequests, Window: cfg.RateLimit.IPWindow}
+	emailLimit := ratelimit.Limit{Algorithm: rateLimitAlgorithm, Requests: cfg.RateLimit.EmailRequests, Window: cfg.RateLimit.EmailWindow}
+	clientLimit := ratelimit.Limit{Algorithm: rateLimitAlgorithm, Requests: cfg.RateLimit.ClientRequests, Window: cfg.RateLimit.ClientWindow}
+
+	// Each endpoint has its own budgets, so a user who mistyped their
+	// password a few times can still request a reset.
+	var onLimited func(r *http.Request)
+	if puzzle != nil {
+		onLimited = func(r *http.Request) {
+			if err := puzzle.ReportAbuse(r.Context()); err != nil {
+				log.Printf("Failed to report abuse: %v", err)
+			}
+		}
+	}
+
+	rateLimit := func(endpoint string) func(http.Handler) http.Handler {
+		return middleware.RateLimit(
+			onLimited,
+			middleware.RateLimitRule{
+				Limiter: ratelimit.NewLimiter(endpoint+":ip", rateLimitStore, ipLimit),
+				Key:     middleware.KeyByIP(cfg.RateLimit.TrustProxy),
+			},
+			middleware.RateLimitRule{
+				Limiter: ratelimit.NewLimiter(endpoint+":email", rateLimitStore, emailLimit),
+				Key:     middleware.KeyByFormValue("email"),
+			},
+			middleware.RateLimitRule{
+				Limiter: ratelimit.NewLimiter(endpoint+":client", rateLimitStore, clientLimit),
+				Key:     middleware.KeyByFormValue("client_id"),
+			},
+		)
+	}
+
+	// Re-authentication draws on the login budgets of the client address
+	// and, as it has no email field, of the signed-in user.
+	reauthLimit := middleware.RateLimit(
+		onLimited,
+		middleware.RateLimitRule{
+			Limiter: ratelimit.NewLimiter("auth-password:ip", rateLimitStore, ipLimit),
+			Key:     middleware.KeyByIP(cfg.RateLimit.TrustProxy),
+		},
+		middleware.RateLimitRule{
+			Limiter: ratelimit.NewLimiter("auth-password:user", rateLimitStore, emailLimit),
+			Key:     middleware.KeyByUser,
+		},
 	)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", authHandlers.ServeAuth)
 	mux.HandleFunc("/auth", authHandlers.ServeAuth)
-	mux.HandleFunc("/auth/email", authHandlers.HandleEmail)
-	mux.HandleFunc("/auth/password", authHandlers.HandlePassword)
+	mux.Handle("/auth/email", rateLimit("auth-email")(http.HandlerFunc(authHandlers.HandleEmail)))
+	mux.Handle("/auth/password", rateLimit("auth-password")(http.HandlerFunc(authHandlers.HandlePassword)))
+	mux.HandleFunc("/auth/password/change", authHandlers.HandleChangePassword)
 	mux.HandleFunc("/auth/confirm", authHandlers.HandleConfirm)
+	mux.HandleFunc("GET /auth/unlock", authHandlers.HandleUnlock)
+	mux.HandleFunc("GET /auth/signup", authHandlers.ServeSignup)
+	mux.HandleFunc("POST /auth/signup", authHandlers.HandleSignup)
 
 	mux.HandleFunc("/reset", pwdResetHandlers.ServeReset)
-	mux.HandleFunc("/reset/request", pwdResetHandlers.HandleRequest)
+	mux.Handle("/reset/request", rateLimit("reset-request")(http.HandlerFunc(pwdResetHandlers.HandleRequest)))
 	mux.HandleFunc("/reset/confirm", pwdResetHandlers.HandleConfirm)
 	mux.HandleFunc("/reset/complete", pwdResetHandlers.HandleComplete)
 
+	requireRecentAuth := middleware.RequireRecentAuth(cfg.Session.ReauthMaxAge)
+
 	accountMux := http.NewServeMux()
 	accountMux.HandleFunc("/account", accountHandlers.ServeAccount)
-	accountMux.HandleFunc("/account/email", accountHandlers.HandleChangeEmail)
-	accountMux.HandleFunc("/account/password", accountHandlers.HandleChangePassword)
-	accountMux.HandleFunc("/account/delete", accountHandlers.HandleDeleteAccount)
+	accountMux.HandleFunc("GET /account/reauth", accountHandlers.ServeReauth)
+	accountMux.Handle("POST /account/reauth", reauthLimit(http.HandlerFunc(accountHandlers.HandleReauth)))
+	accountMux.HandleFunc("POST /account/locale", accountHandlers.HandleChangeLocale)
+	accountMux.Handle("/account/email", requireRecentAuth(http.HandlerFunc(accountHandlers.HandleChangeEmail)))
+	accountMux.Handle("/account/password", requireRecentAuth(http.HandlerFunc(accountHandlers.HandleChangePassword)))
+	accountMux.Handle("/account/delete", requireRecentAuth(http.HandlerFunc(accountHandlers.HandleDeleteAccount)))
 
-	mux.Handle("/account/", middleware.Auth(userRepo)(accountMux))
+	if cfg.Email.WebhookSecret != "" {
+		outboxHandlers := handlers.NewOutboxHandlers(outboxWorker, cfg.Email.WebhookSecret)
+		mux.HandleFunc("GET /internal/email-outbox", outboxHandlers.HandleStats)
 
-	handler := middleware.Recovery(middleware.Logger(mux))
+		bounceHandlers := handlers.NewBounceHandlers(suppressionRepo, cfg.Email.WebhookSecret)
+		mux.HandleFunc("POST /internal/email-events", bounceHandlers.HandleWebhook)
+	}
+
+	if devSender != nil {
+		devMailHandlers := handlers.NewDevMailHandlers(tmpls, devSender)
+		mux.HandleFunc("GET /_dev/mail", devMailHandlers.ServeInbox)
+		mux.HandleFunc("GET /_dev/mail/{id}", devMailHandlers.ServeMessage)
+		mux.HandleFunc("GET /_dev/mail/{id}/html", devMailHandlers.ServeMessageHTML)
+	}
+
+	// Clients' own pages may call the account routes with the session
+	// cookie from the origins they registered. There are no token or
+	// userinfo endpoints; /account is the only cross-origin API.
+	cors := middleware.CORS(func(r *http.Request, origin string) bool {
+		return clientRegistry.AllowsOrigin(r.URL.Query().Get("client_id"), origin)
+	})
+
+	// The confirmation link proves the new address on its own and may be
+	// opened in a browser without the session.
+	mux.HandleFunc("GET /account/email/confirm", accountHandlers.HandleConfirmEmail)
+	mux.Handle("/account", cors(middleware.Auth(sessionRepo)(accountMux)))
+	mux.Handle("/account/", cors(middleware.Auth(sessionRepo)(accountMux)))
+
+	csrfKey, err := base64.StdEncoding.DecodeString(cfg.CSRF.Secret)
+	if err != nil {
+		log.Fatalf("Failed to decode CSRF_SECRET: %v", err)
+	}
+	if len(csrfKey) == 0 {
+		csrfKey = make([]byte, 32)
+		if _, err := rand.Read(csrfKey); err != nil {
+			log.Fatalf("Failed to generate CSRF key: %v", err)
+		}
+	}
+	// The internal endpoints are called by other services, not browsers.
+	// Cross-origin account changes are only taken from the origins of the
+	// client the session was started for.
+	csrf := middleware.CSRF(csrfKey, strings.HasPrefix(baseURL, "https://"), func(r *http.Request, origin string) bool {
+		clientID := middleware.SessionClient(sessionRepo, r)
+		return clientID != "" && clientRegistry.AllowsOrigin(clientID, origin)
+	}, "/account", "/internal/")
+
+	securityHeaders := middleware.SecurityHeaders(middleware.SecurityPolicy{
+		HSTSMaxAge:            cfg.Security.HSTSMaxAge,
+		HSTSIncludeSubdomains: cfg.Security.HSTSIncludeSubdomains,
+		FrameAncestors:        cfg.Security.FrameAncestors,
+		ReferrerPolicy:        cfg.Security.ReferrerPolicy,
+		PermissionsPolicy:     cfg.Security.PermissionsPolicy,
+	})
+
+	jsonBody := middleware.JSONBody("/internal/")
+
+	handler := middleware.Recovery(middleware.Logger(securityHeaders(jsonBody(csrf(mux)))))
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
@@ -131,5 +475,8 @@ func main() {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
+	stopWorker()
+	<-workerDone
+
 	log.Println("Server stopped")
 }
@@ -4,12 +4,25 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
-	Server ServerConfig
-	DB     DBConfig
-	SMTP   SMTPConfig
+	Server    ServerConfig
+	DB        DBConfig
+	SMTP      SMTPConfig
+	Email     EmailConfig
+	Session   SessionConfig
+	Password  PasswordConfig
+	Outbox    OutboxConfig
+	I18n      I18nConfig
+	Clients   ClientsConfig
+	RateLimit RateLimitConfig
+	Lockout   LockoutConfig
+	PoW       PoWConfig
+	Auth      AuthConfig
+	CSRF      CSRFConfig
+	Security  SecurityConfig
 }
 
 type ServerConfig struct {
@@ -27,33 +40,256 @@ type DBConfig struct {
 }
 
 type SMTPConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	From     string
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	From       string
+	TLSMode    string // none | starttls | implicit
+	CAFile     string
+	ServerName string
+	AuthMethod string // plain | login | cram-md5
+
+	PoolSize       int
+	KeepAlive      time.Duration
+	IdleTimeout    time.Duration
+	CommandTimeout time.Duration
+
+	DKIMDomain   string
+	DKIMSelector string
+	DKIMKeyFile  string // PEM, RSA or Ed25519
+}
+
+type EmailConfig struct {
+	Driver string // smtp | dev
+	DevDir string // optional, persists dev mail between restarts
+
+	BounceMaildir      string // optional, DSN bounces delivered here are processed
+	BouncePollInterval time.Duration
+	WebhookSecret      string // bearer token for the internal email endpoints, disabled when empty
+}
+
+type SessionConfig struct {
+	TTL          time.Duration
+	ReauthMaxAge time.Duration
+}
+
+type PasswordConfig struct {
+	Argon2Memory      int // KiB
+	Argon2Iterations  int
+	Argon2Parallelism int
+	Peppers           string // "<version>:<base64 key>,..."
+	PepperFile        string
+	PepperVersion     int // 0 selects the highest configured version
+	MinLength         int
+	MaxLength         int
+	RequireUpper      bool
+	RequireLower      bool
+	RequireDigit      bool
+	RequireSymbol     bool
+	MinStrength       int // 0-4
+	PolicyFile        string
+	BreachListFile    string        // HIBP "ordered by hash" download
+	BreachListFormat  string        // sha1 | ntlm
+	BreachBloomFile   string        // built with cmd/breachfilter
+	HistorySize       int           // recent passwords that cannot be reused, 0 disables
+	MaxAge            time.Duration // 0 disables expiry
+	FirebaseSignerKey string        // base64, verifies imported Firebase hashes
+}
+
+type OutboxConfig struct {
+	MaxAttempts  int
+	PollInterval time.Duration
+}
+
+type I18nConfig struct {
+	DefaultLocale string // must have a catalog in i18n/locales
+}
+
+type ClientsConfig struct {
+	File string // JSON registry of client branding, optional
+}
+
+// RateLimitConfig limits the email, password and reset request endpoints.
+// Each key gets Requests per Window; zero Requests disables that key.
+type RateLimitConfig struct {
+	Backend    string // memory | postgres
+	Algorithm  string // token-bucket | sliding-window
+	TrustProxy bool   // take the client IP from X-Forwarded-For
+
+	IPRequests     int
+	IPWindow       time.Duration
+	EmailRequests  int
+	EmailWindow    time.Duration
+	ClientRequests int
+	ClientWindow   time.Duration
+}
+
+// LockoutConfig slows down and locks accounts after failed logins. Each
+// failure doubles the wait before the next attempt; every Threshold
+// failures lock the account, for Duration doubling with each lock.
+type LockoutConfig struct {
+	Threshold   int // 0 disables locking
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// PoWConfig enables the proof-of-work challenge on the email, signup and
+// reset request forms.
+type PoWConfig struct {
+	Enabled        bool
+	Secret         string // base64, signs challenges; random per process when empty
+	Difficulty     int    // leading zero bits
+	MaxDifficulty  int
+	TTL            time.Duration
+	AbuseWindow    time.Duration
+	AbuseThreshold int // rate limit rejections per window before escalating
+}
+
+// AuthConfig tunes the login and signup flow. In enumeration-safe mode no
+// response tells whether an address has an account; unknown addresses are
+// emailed a signup link instead.
+type AuthConfig struct {
+	EnumerationSafe bool
+}
+
+// CSRFConfig holds the key signing CSRF tokens. Instances behind one load
+// balancer need the same Secret.
+type CSRFConfig struct {
+	Secret string // base64; random per process when empty
+}
+
+// SecurityConfig sets the security headers sent with every response.
+type SecurityConfig struct {
+	HSTSMaxAge            time.Duration // 0 omits Strict-Transport-Security
+	HSTSIncludeSubdomains bool
+	FrameAncestors        string // CSP frame-ancestors source list
+	ReferrerPolicy        string
+	PermissionsPolicy     string
 }
 
 func Load() (*Config, error) {
+	// Port 465 is submission over implicit TLS, anything else is expected
+	// to upgrade with STARTTLS.
+	smtpPort := getEnvInt("SMTP_PORT", 587)
+	smtpTLSMode := "starttls"
+	if smtpPort == 465 {
+		smtpTLSMode = "implicit"
+	}
+
 	cfg := &Config{
 		Server: ServerConfig{
 			Port: getEnvInt("SERVER_PORT", 8080),
 			Host: getEnv("SERVER_HOST", "0.0.0.0"),
 		},
-		DB: DBConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "auth_user"),
-			Password: getEnv("DB_PASSWORD", ""),
-			Name:     getEnv("DB_NAME", "auth_db"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
-		},
+		DB: LoadDBConfig(),
 		SMTP: SMTPConfig{
-			Host:     getEnv("SMTP_HOST", ""),
-			Port:     getEnvInt("SMTP_PORT", 587),
-			User:     getEnv("SMTP_USER", ""),
-			Password: getEnv("SMTP_PASSWORD", ""),
-			From:     getEnv("SMTP_FROM", "noreply@example.com"),
+			Host:           getEnv("SMTP_HOST", ""),
+			Port:           smtpPort,
+			User:           getEnv("SMTP_USER", ""),
+			Password:       getEnv("SMTP_PASSWORD", ""),
+			From:           getEnv("SMTP_FROM", "noreply@example.com"),
+			TLSMode:        getEnv("SMTP_TLS_MODE", smtpTLSMode),
+			CAFile:         getEnv("SMTP_TLS_CA_FILE", ""),
+			ServerName:     getEnv("SMTP_TLS_SERVER_NAME", ""),
+			AuthMethod:     getEnv("SMTP_AUTH", "plain"),
+			PoolSize:       getEnvInt("SMTP_POOL_SIZE", 2),
+			KeepAlive:      getEnvDuration("SMTP_KEEPALIVE", 30*time.Second),
+			IdleTimeout:    getEnvDuration("SMTP_IDLE_TIMEOUT", 5*time.Minute),
+			CommandTimeout: getEnvDuration("SMTP_TIMEOUT", 30*time.Second),
+
+			DKIMDomain:   getEnv("SMTP_DKIM_DOMAIN", ""),
+			DKIMSelector: getEnv("SMTP_DKIM_SELECTOR", ""),
+			DKIMKeyFile:  getEnv("SMTP_DKIM_KEY_FILE", ""),
+		},
+		Email: EmailConfig{
+			Driver: getEnv("EMAIL_DRIVER", "smtp"),
+			DevDir: getEnv("EMAIL_DEV_DIR", ""),
+
+			BounceMaildir:      getEnv("EMAIL_BOUNCE_MAILDIR", ""),
+			BouncePollInterval: getEnvDuration("EMAIL_BOUNCE_POLL_INTERVAL", time.Minute),
+			WebhookSecret:      getEnv("EMAIL_WEBHOOK_SECRET", ""),
+		},
+		Session: SessionConfig{
+			TTL:          getEnvDuration("SESSION_TTL", 24*time.Hour),
+			ReauthMaxAge: getEnvDuration("SESSION_REAUTH_MAX_AGE", 10*time.Minute),
+		},
+		Password: PasswordConfig{
+			Argon2Memory:      getEnvInt("ARGON2_MEMORY", 19*1024),
+			Argon2Iterations:  getEnvInt("ARGON2_ITERATIONS", 2),
+			Argon2Parallelism: getEnvInt("ARGON2_PARALLELISM", 1),
+			Peppers:           getEnv("PASSWORD_PEPPERS", ""),
+			PepperFile:        getEnv("PASSWORD_PEPPER_FILE", ""),
+			PepperVersion:     getEnvInt("PASSWORD_PEPPER_VERSION", 0),
+			MinLength:         getEnvInt("PASSWORD_MIN_LENGTH", 8),
+			MaxLength:         getEnvInt("PASSWORD_MAX_LENGTH", 128),
+			RequireUpper:      getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+			RequireLower:      getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+			RequireDigit:      getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+			RequireSymbol:     getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+			MinStrength:       getEnvInt("PASSWORD_MIN_STRENGTH", 2),
+			PolicyFile:        getEnv("PASSWORD_POLICY_FILE", ""),
+			BreachListFile:    getEnv("PASSWORD_BREACH_LIST_FILE", ""),
+			BreachListFormat:  getEnv("PASSWORD_BREACH_LIST_FORMAT", "sha1"),
+			BreachBloomFile:   getEnv("PASSWORD_BREACH_BLOOM_FILE", ""),
+			HistorySize:       getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+			MaxAge:            getEnvDuration("PASSWORD_MAX_AGE", 0),
+			FirebaseSignerKey: getEnv("FIREBASE_SIGNER_KEY", ""),
+		},
+		Outbox: OutboxConfig{
+			MaxAttempts:  getEnvInt("EMAIL_OUTBOX_MAX_ATTEMPTS", 8),
+			PollInterval: getEnvDuration("EMAIL_OUTBOX_POLL_INTERVAL", 5*time.Second),
+		},
+		I18n: I18nConfig{
+			DefaultLocale: getEnv("DEFAULT_LOCALE", "en"),
+		},
+		Clients: ClientsConfig{
+			File: getEnv("CLIENTS_FILE", ""),
+		},
+		RateLimit: RateLimitConfig{
+			Backend:    getEnv("RATE_LIMIT_BACKEND", "memory"),
+			Algorithm:  getEnv("RATE_LIMIT_ALGORITHM", "token-bucket"),
+			TrustProxy: getEnvBool("RATE_LIMIT_TRUST_PROXY", false),
+
+			IPRequests:     getEnvInt("RATE_LIMIT_IP_REQUESTS", 30),
+			IPWindow:       getEnvDuration("RATE_LIMIT_IP_WINDOW", time.Minute),
+			EmailRequests:  getEnvInt("RATE_LIMIT_EMAIL_REQUESTS", 10),
+			EmailWindow:    getEnvDuration("RATE_LIMIT_EMAIL_WINDOW", 15*time.Minute),
+			ClientRequests: getEnvInt("RATE_LIMIT_CLIENT_REQUESTS", 600),
+			ClientWindow:   getEnvDuration("RATE_LIMIT_CLIENT_WINDOW", time.Minute),
+		},
+		Lockout: LockoutConfig{
+			Threshold:   getEnvInt("LOCKOUT_THRESHOLD", 5),
+			BaseDelay:   getEnvDuration("LOCKOUT_BASE_DELAY", time.Second),
+			MaxDelay:    getEnvDuration("LOCKOUT_MAX_DELAY", time.Minute),
+			Duration:    getEnvDuration("LOCKOUT_DURATION", 15*time.Minute),
+			MaxDuration: getEnvDuration("LOCKOUT_MAX_DURATION", 24*time.Hour),
+		},
+		PoW: PoWConfig{
+			Enabled:        getEnvBool("POW_ENABLED", false),
+			Secret:         getEnv("POW_SECRET", ""),
+			Difficulty:     getEnvInt("POW_DIFFICULTY", 16),
+			MaxDifficulty:  getEnvInt("POW_MAX_DIFFICULTY", 20),
+			TTL:            getEnvDuration("POW_TTL", 10*time.Minute),
+			AbuseWindow:    getEnvDuration("POW_ABUSE_WINDOW", 5*time.Minute),
+			AbuseThreshold: getEnvInt("POW_ABUSE_THRESHOLD", 20),
+		},
+		Auth: AuthConfig{
+			EnumerationSafe: getEnvBool("AUTH_ENUMERATION_SAFE", false),
+		},
+		CSRF: CSRFConfig{
+			Secret: getEnv("CSRF_SECRET", ""),
+		},
+		Security: SecurityConfig{
+			HSTSMaxAge:            getEnvDuration("SECURITY_HSTS_MAX_AGE", 365*24*time.Hour),
+			HSTSIncludeSubdomains: getEnvBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS", false),
+			FrameAncestors:        getEnv("SECURITY_FRAME_ANCESTORS", "'none'"),
+			// Keeps tokens in URLs, such as reset links, from reaching
+			// other sites, while same-origin posts still carry Origin.
+			ReferrerPolicy:    getEnv("SECURITY_REFERRER_POLICY", "same-origin"),
+			PermissionsPolicy: getEnv("SECURITY_PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=(), payment=(), usb=()"),
 		},
 	}
 
@@ -64,18 +300,104 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// LoadDBConfig reads only the database settings, for tools that do not need
+// the rest of the configuration.
+func LoadDBConfig() DBConfig {
+	return DBConfig{
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnvInt("DB_PORT", 5432),
+		User:     getEnv("DB_USER", "auth_user"),
+		Password: getEnv("DB_PASSWORD", ""),
+		Name:     getEnv("DB_NAME", "auth_db"),
+		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+	}
+}
+
 func (c *Config) Validate() error {
 	if c.DB.Password == "" {
 		return fmt.Errorf("DB_PASSWORD is required")
 	}
-	if c.SMTP.Host == "" {
-		return fmt.Errorf("SMTP_HOST is required")
+	switch c.Email.Driver {
+	case "smtp":
+		if c.SMTP.Host == "" {
+			return fmt.Errorf("SMTP_HOST is required")
+		}
+		if c.SMTP.User == "" {
+			return fmt.Errorf("SMTP_USER is required")
+		}
+		if c.SMTP.Password == "" {
+			return fmt.Errorf("SMTP_PASSWORD is required")
+		}
+		switch c.SMTP.TLSMode {
+		case "none", "starttls", "implicit":
+		default:
+			return fmt.Errorf("SMTP_TLS_MODE must be none, starttls or implicit")
+		}
+		switch c.SMTP.AuthMethod {
+		case "plain", "login", "cram-md5":
+		default:
+			return fmt.Errorf("SMTP_AUTH must be plain, login or cram-md5")
+		}
+		if c.SMTP.DKIMKeyFile != "" && (c.SMTP.DKIMDomain == "" || c.SMTP.DKIMSelector == "") {
+			return fmt.Errorf("SMTP_DKIM_DOMAIN and SMTP_DKIM_SELECTOR are required with SMTP_DKIM_KEY_FILE")
+		}
+	case "dev":
+	default:
+		return fmt.Errorf("EMAIL_DRIVER must be smtp or dev")
+	}
+	if c.Password.Argon2Memory < 8*c.Password.Argon2Parallelism {
+		return fmt.Errorf("ARGON2_MEMORY must be at least 8 KiB per ARGON2_PARALLELISM")
+	}
+	if c.Password.Argon2Iterations < 1 {
+		return fmt.Errorf("ARGON2_ITERATIONS must be at least 1")
+	}
+	if c.Password.Argon2Parallelism < 1 || c.Password.Argon2Parallelism > 255 {
+		return fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	if c.Password.MinLength < 1 || c.Password.MaxLength < c.Password.MinLength {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH must be positive and not above PASSWORD_MAX_LENGTH")
+	}
+	if c.Outbox.MaxAttempts < 1 {
+		return fmt.Errorf("EMAIL_OUTBOX_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.Outbox.PollInterval <= 0 {
+		return fmt.Errorf("EMAIL_OUTBOX_POLL_INTERVAL must be positive")
 	}
-	if c.SMTP.User == "" {
-		return fmt.Errorf("SMTP_USER is required")
+	if c.Password.HistorySize < 0 {
+		return fmt.Errorf("PASSWORD_HISTORY_SIZE must not be negative")
 	}
-	if c.SMTP.Password == "" {
-		return fmt.Errorf("SMTP_PASSWORD is required")
+	if c.Password.MinStrength < 0 || c.Password.MinStrength > 4 {
+		return fmt.Errorf("PASSWORD_MIN_STRENGTH must be between 0 and 4")
+	}
+	switch c.RateLimit.Backend {
+	case "memory", "postgres":
+	default:
+		return fmt.Errorf("RATE_LIMIT_BACKEND must be memory or postgres")
+	}
+	switch c.RateLimit.Algorithm {
+	case "token-bucket", "sliding-window":
+	default:
+		return fmt.Errorf("RATE_LIMIT_ALGORITHM must be token-bucket or sliding-window")
+	}
+	if c.RateLimit.IPWindow <= 0 || c.RateLimit.EmailWindow <= 0 || c.RateLimit.ClientWindow <= 0 {
+		return fmt.Errorf("RATE_LIMIT_*_WINDOW must be positive")
+	}
+	if c.Lockout.Threshold < 0 {
+		return fmt.Errorf("LOCKOUT_THRESHOLD must not be negative")
+	}
+	if c.Lockout.Threshold > 0 && c.Lockout.Duration <= 0 {
+		return fmt.Errorf("LOCKOUT_DURATION must be positive")
+	}
+	if c.PoW.Enabled {
+		if c.PoW.Difficulty < 1 || c.PoW.MaxDifficulty < c.PoW.Difficulty || c.PoW.MaxDifficulty > 32 {
+			return fmt.Errorf("POW_DIFFICULTY must be positive and not above POW_MAX_DIFFICULTY, which is at most 32")
+		}
+		if c.PoW.TTL <= 0 || c.PoW.AbuseWindow <= 0 {
+			return fmt.Errorf("POW_TTL and POW_ABUSE_WINDOW must be positive")
+		}
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		return fmt.Errorf("SECURITY_HSTS_MAX_AGE must not be negative")
 	}
 	return nil
 }
@@ -102,3 +424,21 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,117 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type StoredMessage struct {
+	ID     string    `json:"id"`
+	From   string    `json:"from"`
+	SentAt time.Time `json:"sent_at"`
+	Message
+}
+
+// DevSender is a Sender for local development. It keeps every message in
+// memory and, when dir is set, also writes it there as JSON so the inbox
+// survives restarts. Nothing is ever delivered.
+type DevSender struct {
+	from     string
+	dir      string
+	mu       sync.RWMutex
+	messages []StoredMessage
+}
+
+func NewDevSender(from, dir string) (*DevSender, error) {
+	s := &DevSender{from: from, dir: dir}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stored mail: %w", err)
+		}
+		var msg StoredMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse stored mail %s: %w", file, err)
+		}
+		s.messages = append(s.messages, msg)
+	}
+
+	slices.SortFunc(s.messages, func(a, b StoredMessage) int {
+		return a.SentAt.Compare(b.SentAt)
+	})
+
+	return s, nil
+}
+
+func (s *DevSender) Send(msg *Message) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	stored := StoredMessage{
+		ID:      hex.EncodeToString(id),
+		From:    s.from,
+		SentAt:  time.Now(),
+		Message: *msg,
+	}
+
+	if s.dir != "" {
+		data, err := json.MarshalIndent(stored, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		name := fmt.Sprintf("%s-%s.json", stored.SentAt.Format("20060102T150405"), stored.ID)
+		if err := os.WriteFile(filepath.Join(s.dir, name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.messages = append(s.messages, stored)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Messages returns all stored messages, newest first.
+func (s *DevSender) Messages() []StoredMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := slices.Clone(s.messages)
+	slices.Reverse(messages)
+	return messages
+}
+
+func (s *DevSender) Message(id string) (StoredMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, msg := range s.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return StoredMessage{}, false
+}
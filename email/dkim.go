@@ -0,0 +1,251 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// dkimSignedHeaders are the headers Message.Build writes, all of which
+// are covered by the signature.
+var dkimSignedHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+}
+
+// DKIMSigner adds an RFC 6376 DKIM-Signature header to built messages
+// using relaxed/relaxed canonicalization. RSA keys sign with rsa-sha256,
+// Ed25519 keys with ed25519-sha256 (RFC 8463).
+type DKIMSigner struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+}
+
+func NewDKIMSigner(domain, selector string, key crypto.Signer) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim domain and selector are required")
+	}
+
+	var algorithm string
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PublicKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported dkim key type %T", key)
+	}
+
+	return &DKIMSigner{
+		domain:    domain,
+		selector:  selector,
+		key:       key,
+		algorithm: algorithm,
+	}, nil
+}
+
+// LoadDKIMSigner reads a PEM encoded PKCS#1 or PKCS#8 private key.
+func LoadDKIMSigner(domain, selector, keyFile string) (*DKIMSigner, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dkim key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("dkim key file is not PEM encoded")
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dkim key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported dkim key type %T", key)
+	}
+
+	return NewDKIMSigner(domain, selector, signer)
+}
+
+// DNSRecord returns the TXT record to publish at
+// <selector>._domainkey.<domain>.
+func (s *DKIMSigner) DNSRecord() (string, error) {
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	}
+	return "", fmt.Errorf("unsupported dkim key type %T", s.key)
+}
+
+// Sign returns msg with a DKIM-Signature header prepended.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("message has no body")
+	}
+
+	fields := splitHeaderFields(header)
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var canonical bytes.Buffer
+	for _, name := range dkimSignedHeaders {
+		field, ok := lastHeaderField(fields, name)
+		if !ok {
+			continue
+		}
+		signed = append(signed, strings.ToLower(name))
+		canonical.WriteString(relaxedHeader(field))
+		canonical.WriteString("\r\n")
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.algorithm,
+		s.domain,
+		s.selector,
+		time.Now().Unix(),
+		strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+
+	// The signature header itself is hashed last, with an empty b= and
+	// without its trailing CRLF.
+	canonical.WriteString(relaxedHeader("DKIM-Signature: " + value))
+	digest := sha256.Sum256(canonical.Bytes())
+
+	var sig []byte
+	var err error
+	switch s.algorithm {
+	case "rsa-sha256":
+		sig, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case "ed25519-sha256":
+		sig, err = s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: ")
+	out.WriteString(value)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(sig)))
+	out.WriteString("\r\n")
+	out.Write(msg)
+
+	return out.Bytes(), nil
+}
+
+// foldBase64 splits long signatures over continuation lines. Whitespace
+// inside b= is ignored by verifiers.
+func foldBase64(s string) string {
+	const width = 72
+
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n\t")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// splitHeaderFields splits a raw header block into fields, keeping
+// continuation lines attached to their field.
+func splitHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if len(fields) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func lastHeaderField(fields []string, name string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		fieldName, _, ok := strings.Cut(fields[i], ":")
+		if ok && strings.EqualFold(strings.TrimSpace(fieldName), name) {
+			return fields[i], true
+		}
+	}
+	return "", false
+}
+
+// relaxedHeader implements the "relaxed" header canonicalization of
+// RFC 6376 section 3.4.2, without the trailing CRLF.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// relaxedBody implements the "relaxed" body canonicalization of
+// RFC 6376 section 3.4.4.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRightFunc(line, isWSP)
+		lines[i] = collapseWSP(line)
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func collapseWSP(s string) string {
+	var b strings.Builder
+	inWSP := false
+	for _, r := range s {
+		if isWSP(r) {
+			inWSP = true
+			continue
+		}
+		if inWSP {
+			b.WriteByte(' ')
+			inWSP = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
@@ -0,0 +1,201 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelaxedCanonicalization(t *testing.T) {
+	// Example from RFC 6376 section 3.4.5.
+	if got := relaxedHeader("A: X"); got != "a:X" {
+		t.Errorf("relaxedHeader(A) = %q", got)
+	}
+	if got := relaxedHeader("B : Y\t\r\n\tZ  "); got != "b:Y Z" {
+		t.Errorf("relaxedHeader(B) = %q", got)
+	}
+	if got := string(relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))); got != " C\r\nD E\r\n" {
+		t.Errorf("relaxedBody = %q", got)
+	}
+}
+
+func TestDKIMSignRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDKIMSign(t, key)
+}
+
+func TestDKIMSignEd25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDKIMSign(t, key)
+}
+
+func TestLoadDKIMSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := LoadDKIMSigner("example.com", "mail", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.algorithm != "rsa-sha256" {
+		t.Errorf("algorithm = %q, want rsa-sha256", signer.algorithm)
+	}
+}
+
+func testDKIMSign(t *testing.T, key crypto.Signer) {
+	t.Helper()
+
+	signer, err := NewDKIMSigner("example.com", "mail", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{
+		To:      "Jane <jane@example.org>",
+		Subject: "Your login link – ünïcode",
+		Text:    "Hello,\n\nfollow this link.  \n",
+		HTML:    "<p>Hello,</p>\n<p>follow <a href=\"https://example.com/\">this link</a>.</p>\n",
+	}
+	raw, err := msg.Build("noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.Sign(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := signer.DNSRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyDKIM(signed, record); err != "" {
+		t.Fatalf("signature does not verify: %s", err)
+	}
+
+	tampered := bytes.Replace(signed, []byte("follow"), []byte("fo1low"), 1)
+	if err := verifyDKIM(tampered, record); err == "" {
+		t.Fatal("signature verifies after body was modified")
+	}
+
+	tampered = bytes.Replace(signed, []byte("To: "), []byte("To:  "), 1)
+	if err := verifyDKIM(tampered, record); err != "" {
+		t.Fatalf("relaxed canonicalization should ignore whitespace: %s", err)
+	}
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg against the public key
+// in a DNS TXT record, returning a description of the failure or "".
+func verifyDKIM(msg []byte, record string) string {
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return "no body"
+	}
+	fields := splitHeaderFields(header)
+
+	sigField, ok := lastHeaderField(fields, "DKIM-Signature")
+	if !ok {
+		return "no DKIM-Signature header"
+	}
+	_, sigValue, _ := strings.Cut(sigField, ":")
+	tags := parseTags(sigValue)
+
+	if tags["c"] != "relaxed/relaxed" {
+		return "unexpected canonicalization " + tags["c"]
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return "body hash mismatch"
+	}
+
+	var canonical bytes.Buffer
+	for _, name := range strings.Split(tags["h"], ":") {
+		field, ok := lastHeaderField(fields, name)
+		if !ok {
+			return "signed header missing: " + name
+		}
+		canonical.WriteString(relaxedHeader(field))
+		canonical.WriteString("\r\n")
+	}
+
+	// The signature header is hashed with an empty b= value.
+	i := strings.LastIndex(sigField, "; b=")
+	if i < 0 {
+		return "no b= tag"
+	}
+	canonical.WriteString(relaxedHeader(sigField[:i+len("; b=")]))
+	digest := sha256.Sum256(canonical.Bytes())
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return "invalid signature encoding"
+	}
+
+	keyTags := parseTags(record)
+	pubData, err := base64.StdEncoding.DecodeString(keyTags["p"])
+	if err != nil {
+		return "invalid public key encoding"
+	}
+
+	switch tags["a"] {
+	case "rsa-sha256":
+		pub, err := x509.ParsePKIXPublicKey(pubData)
+		if err != nil {
+			return err.Error()
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return "not an RSA key"
+		}
+		if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, digest[:], sig); err != nil {
+			return err.Error()
+		}
+	case "ed25519-sha256":
+		if !ed25519.Verify(ed25519.PublicKey(pubData), digest[:], sig) {
+			return "ed25519 verification failed"
+		}
+	default:
+		return "unexpected algorithm " + tags["a"]
+	}
+
+	return ""
+}
+
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
@@ -0,0 +1,105 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+var ErrNotDSN = errors.New("message is not a delivery status notification")
+
+// ParseDSN extracts failed recipients from an RFC 3464 delivery status
+// notification (multipart/report; report-type=delivery-status). Delayed
+// and successful recipients are skipped.
+func ParseDSN(r io.Reader) ([]Bounce, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, ErrNotDSN
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, ErrNotDSN
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read report part: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType == "message/delivery-status" || partType == "message/global-delivery-status" {
+			return parseDeliveryStatus(part)
+		}
+	}
+}
+
+// parseDeliveryStatus reads the per-message field group followed by one
+// group per recipient, each terminated by a blank line.
+func parseDeliveryStatus(r io.Reader) ([]Bounce, error) {
+	tr := textproto.NewReader(bufio.NewReader(r))
+
+	if _, err := tr.ReadMIMEHeader(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse delivery status: %w", err)
+	}
+
+	var bounces []Bounce
+	for {
+		fields, err := tr.ReadMIMEHeader()
+		if len(fields) > 0 {
+			if bounce, ok := recipientBounce(fields); ok {
+				bounces = append(bounces, bounce)
+			}
+		}
+		if err == io.EOF {
+			return bounces, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse delivery status: %w", err)
+		}
+	}
+}
+
+func recipientBounce(fields textproto.MIMEHeader) (Bounce, bool) {
+	if !strings.EqualFold(strings.TrimSpace(fields.Get("Action")), "failed") {
+		return Bounce{}, false
+	}
+
+	recipient := fields.Get("Final-Recipient")
+	if recipient == "" {
+		recipient = fields.Get("Original-Recipient")
+	}
+	// Address fields are "<address-type>; <address>", e.g. "rfc822; a@b.c".
+	_, address, ok := strings.Cut(recipient, ";")
+	if !ok {
+		return Bounce{}, false
+	}
+	address = strings.Trim(strings.TrimSpace(address), "<>")
+	if address == "" {
+		return Bounce{}, false
+	}
+
+	status := strings.TrimSpace(fields.Get("Status"))
+	detail := status
+	if diagnostic := strings.TrimSpace(fields.Get("Diagnostic-Code")); diagnostic != "" {
+		detail += " " + diagnostic
+	}
+
+	return Bounce{
+		Type:      SuppressBounce,
+		Email:     address,
+		Permanent: strings.HasPrefix(status, "5."),
+		Detail:    strings.TrimSpace(detail),
+	}, true
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BounceProcessor reads DSN bounce messages delivered to a local maildir,
+// adds permanently failed recipients to the suppression list and moves the
+// messages to cur/ so they are only processed once.
+type BounceProcessor struct {
+	dir      string
+	list     Suppressions
+	interval time.Duration
+}
+
+func NewBounceProcessor(dir string, list Suppressions, interval time.Duration) *BounceProcessor {
+	return &BounceProcessor{dir: dir, list: list, interval: interval}
+}
+
+// Run processes new messages until ctx is cancelled.
+func (p *BounceProcessor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.processNew(ctx); err != nil {
+			log.Printf("Bounce processor: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *BounceProcessor) processNew(ctx context.Context) error {
+	entries, err := os.ReadDir(filepath.Join(p.dir, "new"))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if err := p.processFile(ctx, filepath.Join(p.dir, "new", name)); err != nil {
+			// Leave the message in new/ to try again on the next run.
+			log.Printf("Bounce processor: %s: %v", name, err)
+			continue
+		}
+
+		if err := os.Rename(filepath.Join(p.dir, "new", name), filepath.Join(p.dir, "cur", name+":2,S")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *BounceProcessor) processFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Messages that cannot be parsed will not parse on the next run either,
+	// so they are skipped rather than retried.
+	bounces, err := ParseDSN(f)
+	if err != nil {
+		log.Printf("Bounce processor: skipping %s: %v", filepath.Base(path), err)
+		return nil
+	}
+
+	for _, bounce := range bounces {
+		if err := bounce.Record(ctx, p.list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+type OutboxEntry struct {
+	ID       int
+	Message  Message
+	Attempts int
+}
+
+type OutboxDepth struct {
+	Pending int `json:"pending"`
+	Dead    int `json:"dead"`
+}
+
+// Outbox is the persistent queue the Worker drains. Messages are enqueued by
+// the handlers in the same transaction as the token they deliver.
+type Outbox interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error)
+	MarkSent(ctx context.Context, id int) error
+	MarkFailed(ctx context.Context, id int, errMsg string, nextAttemptAt time.Time, dead bool) error
+	Depth(ctx context.Context) (OutboxDepth, error)
+}
+
+type Worker struct {
+	outbox      Outbox
+	sender      Sender
+	maxAttempts int
+	interval    time.Duration
+	batchSize   int
+}
+
+func NewWorker(outbox Outbox, sender Sender, maxAttempts int, interval time.Duration) *Worker {
+	return &Worker{
+		outbox:      outbox,
+		sender:      sender,
+		maxAttempts: maxAttempts,
+		interval:    interval,
+		batchSize:   10,
+	}
+}
+
+// Run delivers due messages until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := w.deliverBatch(ctx)
+			if err != nil {
+				log.Printf("Email outbox: %v", err)
+			}
+			if err != nil || n < w.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) Depth(ctx context.Context) (OutboxDepth, error) {
+	return w.outbox.Depth(ctx)
+}
+
+func (w *Worker) deliverBatch(ctx context.Context) (int, error) {
+	// The lease keeps other instances from picking up a batch while it is
+	// being delivered.
+	entries, err := w.outbox.Claim(ctx, w.batchSize, 2*time.Minute)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		err := w.sender.Send(&entry.Message)
+		if errors.Is(err, ErrSuppressed) {
+			log.Printf("Email outbox: dropping message %d, recipient is suppressed", entry.ID)
+			if err := w.outbox.MarkSent(ctx, entry.ID); err != nil {
+				return len(entries), err
+			}
+			continue
+		}
+		if err != nil {
+			attempts := entry.Attempts + 1
+			dead := attempts >= w.maxAttempts
+			if dead {
+				log.Printf("Email outbox: giving up on message %d after %d attempts: %v", entry.ID, attempts, err)
+			}
+			if err := w.outbox.MarkFailed(ctx, entry.ID, err.Error(), time.Now().Add(backoff(attempts)), dead); err != nil {
+				return len(entries), err
+			}
+			continue
+		}
+
+		if err := w.outbox.MarkSent(ctx, entry.ID); err != nil {
+			return len(entries), err
+		}
+	}
+
+	return len(entries), nil
+}
+
+// backoff doubles from 30s per attempt up to an hour, with up to 20% jitter.
+func backoff(attempts int) time.Duration {
+	d := 30 * time.Second << min(attempts-1, 7)
+	d = min(d, time.Hour)
+	return d + time.Duration(rand.Int64N(int64(d)/5))
+}
@@ -1,43 +1,388 @@
 package email
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Sender interface {
-	Send(to, subject, body string) error
+	Send(msg *Message) error
 }
 
+const (
+	TLSModeNone     = "none"
+	TLSModeSTARTTLS = "starttls"
+	TLSModeImplicit = "implicit"
+)
+
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+
+	TLSMode    string // none | starttls | implicit
+	CAFile     string // PEM bundle, system roots when empty
+	ServerName string // certificate name, Host when empty
+	AuthMethod string // plain | login | cram-md5
+
+	PoolSize       int           // connections open at once, busy or idle
+	KeepAlive      time.Duration // NOOP interval for idle connections
+	IdleTimeout    time.Duration // idle connections older than this are closed
+	CommandTimeout time.Duration // limit for each SMTP command, none when 0
+
+	DKIM *DKIMSigner // optional
+}
+
+type smtpConn struct {
+	client   *smtp.Client
+	conn     net.Conn
+	timeout  time.Duration
+	lastUsed time.Time
+}
+
+// extend gives the next command timeout to complete. A command that runs
+// out of time fails, and the caller then closes the connection rather than
+// returning it to the pool.
+func (c *smtpConn) extend() {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *smtpConn) noop() error {
+	c.extend()
+	return c.client.Noop()
+}
+
+func (c *smtpConn) reset() error {
+	c.extend()
+	return c.client.Reset()
+}
+
+func (c *smtpConn) quit() {
+	c.extend()
+	c.client.Quit()
+}
+
+// SMTPSender delivers messages over a small pool of persistent SMTP
+// connections. At most PoolSize messages are sent at once; further Sends
+// wait for a connection. Idle connections are kept alive with NOOP and
+// replaced transparently when the server drops them. A message the server
+// rejects leaves its connection in the pool, while one that exceeds
+// CommandTimeout or breaks the connection closes it.
 type SMTPSender struct {
-	host     string
-	port     int
-	username string
-	password string
-	from     string
+	opts      SMTPOptions
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	idle      chan *smtpConn
+	slots     chan struct{} // one per connection in use
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
-	return &SMTPSender{
-		host:     host,
-		port:     port,
-		username: username,
-		password: password,
-		from:     from,
+func NewSMTPSender(opts SMTPOptions) (*SMTPSender, error) {
+	serverName := opts.ServerName
+	if serverName == "" {
+		serverName = opts.Host
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
 	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SMTP CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in SMTP CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	var auth smtp.Auth
+	if opts.Username != "" {
+		switch opts.AuthMethod {
+		case "", AuthPlain:
+			auth = smtp.PlainAuth("", opts.Username, opts.Password, opts.Host)
+		case AuthLogin:
+			auth = &loginAuth{host: opts.Host, username: opts.Username, password: opts.Password}
+		case AuthCRAMMD5:
+			auth = smtp.CRAMMD5Auth(opts.Username, opts.Password)
+		default:
+			return nil, fmt.Errorf("unknown SMTP auth method %q", opts.AuthMethod)
+		}
+	}
+
+	switch opts.TLSMode {
+	case TLSModeNone, TLSModeSTARTTLS, TLSModeImplicit:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", opts.TLSMode)
+	}
+
+	s := &SMTPSender{
+		opts:      opts,
+		tlsConfig: tlsConfig,
+		auth:      auth,
+		idle:      make(chan *smtpConn, max(opts.PoolSize, 1)),
+		slots:     make(chan struct{}, max(opts.PoolSize, 1)),
+		done:      make(chan struct{}),
+	}
+
+	if opts.KeepAlive > 0 {
+		go s.keepAlive()
+	}
+
+	return s, nil
 }
 
-func (s *SMTPSender) Send(to, subject, body string) error {
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+func (s *SMTPSender) Send(msg *Message) error {
+	data, err := msg.Build(s.opts.From)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
 
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", s.from, to, subject, body)
+	if s.opts.DKIM != nil {
+		if data, err = s.opts.DKIM.Sign(data); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(s.opts.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid to address: %w", err)
+	}
 
-	auth := smtp.PlainAuth("", s.username, s.password, s.host)
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
 
-	err := smtp.SendMail(addr, auth, s.from, []string{to}, []byte(msg))
+	conn, err := s.get()
 	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
+	if err := conn.deliver(from.Address, to.Address, data); err != nil {
+		// A rejection is an answer from a working connection; anything
+		// else, such as a timeout, leaves it in an unknown state.
+		var reply *textproto.Error
+		if errors.As(err, &reply) && conn.reset() == nil {
+			s.put(conn)
+		} else {
+			conn.client.Close()
+		}
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	s.put(conn)
+	return nil
+}
+
+// Close shuts down all idle connections.
+func (s *SMTPSender) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		for {
+			select {
+			case conn := <-s.idle:
+				conn.quit()
+			default:
+				return
+			}
+		}
+	})
 	return nil
 }
+
+func (c *smtpConn) deliver(from, to string, data []byte) error {
+	c.extend()
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+	c.extend()
+	if err := c.client.Rcpt(to); err != nil {
+		return err
+	}
+
+	c.extend()
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	c.extend()
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	c.extend()
+	return w.Close()
+}
+
+func (s *SMTPSender) get() (*smtpConn, error) {
+	for {
+		select {
+		case conn := <-s.idle:
+			if s.expired(conn) || conn.noop() != nil {
+				conn.client.Close()
+				continue
+			}
+			return conn, nil
+		default:
+			return s.dial()
+		}
+	}
+}
+
+func (s *SMTPSender) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+	select {
+	case <-s.done:
+		conn.quit()
+	case s.idle <- conn:
+	default:
+		conn.quit()
+	}
+}
+
+func (s *SMTPSender) expired(conn *smtpConn) bool {
+	return s.opts.IdleTimeout > 0 && time.Since(conn.lastUsed) > s.opts.IdleTimeout
+}
+
+func (s *SMTPSender) keepAlive() {
+	ticker := time.NewTicker(s.opts.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		for n := len(s.idle); n > 0; n-- {
+			select {
+			case conn := <-s.idle:
+				if s.expired(conn) || conn.noop() != nil {
+					conn.client.Close()
+					continue
+				}
+				select {
+				case s.idle <- conn:
+				default:
+					conn.quit()
+				}
+			default:
+			}
+		}
+	}
+}
+
+func (s *SMTPSender) dial() (*smtpConn, error) {
+	addr := net.JoinHostPort(s.opts.Host, fmt.Sprint(s.opts.Port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if s.opts.TLSMode == TLSModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &smtpConn{conn: conn, timeout: s.opts.CommandTimeout}
+	c.extend()
+	client, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.client = client
+
+	if s.opts.TLSMode == TLSModeSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("server does not support STARTTLS")
+		}
+		c.extend()
+		if err := client.StartTLS(s.tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if s.auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("server does not support AUTH")
+		}
+		c.extend()
+		if err := client.Auth(s.auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	c.lastUsed = time.Now()
+	return c, nil
+}
+
+// loginAuth implements the non-standard but widely deployed AUTH LOGIN
+// mechanism. Like smtp.PlainAuth it refuses to send credentials over an
+// unencrypted connection to anything but localhost.
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+const (
+	SuppressBounce    = "bounce"
+	SuppressComplaint = "complaint"
+)
+
+// Suppressions is the list of addresses that must not be mailed again,
+// fed by bounce and complaint reports.
+type Suppressions interface {
+	IsSuppressed(ctx context.Context, address string) (bool, error)
+	Suppress(ctx context.Context, address, reason, detail string) error
+}
+
+// Bounce is a single delivery problem reported for an address, either by
+// a webhook or parsed from a DSN.
+type Bounce struct {
+	Type      string `json:"type"` // bounce | complaint
+	Email     string `json:"email"`
+	Permanent bool   `json:"permanent"`
+	Detail    string `json:"detail"`
+}
+
+// ShouldSuppress reports whether the address should stop receiving mail.
+// Soft bounces (full mailbox, greylisting) are left to the outbox retries.
+func (b Bounce) ShouldSuppress() bool {
+	return b.Type == SuppressComplaint || (b.Type == SuppressBounce && b.Permanent)
+}
+
+// Record adds the bounced address to list if the bounce warrants it.
+func (b Bounce) Record(ctx context.Context, list Suppressions) error {
+	if !b.ShouldSuppress() {
+		return nil
+	}
+	return list.Suppress(ctx, normalizeAddress(b.Email), b.Type, b.Detail)
+}
+
+// SuppressingSender refuses to send to suppressed addresses and passes
+// everything else on to the wrapped Sender.
+type SuppressingSender struct {
+	next Sender
+	list Suppressions
+}
+
+func NewSuppressingSender(next Sender, list Suppressions) *SuppressingSender {
+	return &SuppressingSender{next: next, list: list}
+}
+
+func (s *SuppressingSender) Send(msg *Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid to address: %w", err)
+	}
+
+	suppressed, err := s.list.IsSuppressed(context.Background(), normalizeAddress(to.Address))
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	if suppressed {
+		return ErrSuppressed
+	}
+
+	return s.next.Send(msg)
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
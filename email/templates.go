@@ -0,0 +1,149 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/i18n"
+)
+
+// Data is passed to every email template. Subject and Body are only used by
+// the generic notification template; L translates the others and should be
+// the recipient's locale.
+type Data struct {
+	URL     string
+	Subject string
+	Body    string
+	L       *i18n.Localizer
+	Brand   clients.Branding
+}
+
+type Templates struct {
+	html    map[string]*htmltemplate.Template
+	text    *texttemplate.Template
+	clients map[string]*Templates
+}
+
+// Parse loads email/templates. Every email <name> needs <name>.txt, which
+// also defines "<name>.subject", and <name>.html, which is rendered inside
+// the "base" layout from base.html.
+func Parse() *Templates {
+	t, err := parseTemplates("email/templates", "")
+	if err != nil {
+		log.Fatalf("Failed to parse email templates: %v", err)
+	}
+	return t
+}
+
+// parseTemplates loads every email in dir. Files found in overrideDir
+// replace their counterpart from dir.
+func parseTemplates(dir, overrideDir string) (*Templates, error) {
+	pick := func(name string) string {
+		if overrideDir != "" {
+			path := filepath.Join(overrideDir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		return filepath.Join(dir, name)
+	}
+
+	text, err := texttemplate.ParseGlob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if overrideDir != "" {
+		overrides, err := filepath.Glob(filepath.Join(overrideDir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+		if len(overrides) > 0 {
+			// Later definitions of the same templates replace earlier ones.
+			if text, err = text.ParseFiles(overrides...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	base, err := htmltemplate.ParseFiles(pick("base.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	html := make(map[string]*htmltemplate.Template)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".html")
+		if name == "base" {
+			continue
+		}
+
+		tmpl, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if tmpl, err = tmpl.ParseFiles(pick(name + ".html")); err != nil {
+			return nil, err
+		}
+		html[name] = tmpl
+	}
+
+	return &Templates{html: html, text: text, clients: make(map[string]*Templates)}, nil
+}
+
+// AddClientOverrides parses a template set for clientID in which emails
+// found in dir replace the default ones.
+func (t *Templates) AddClientOverrides(clientID, dir string) error {
+	overrides, err := parseTemplates("email/templates", dir)
+	if err != nil {
+		return fmt.Errorf("failed to parse email templates for client %q: %w", clientID, err)
+	}
+	t.clients[clientID] = overrides
+	return nil
+}
+
+// For returns the template set for clientID, which is t itself unless the
+// client overrides any emails.
+func (t *Templates) For(clientID string) *Templates {
+	if overrides, ok := t.clients[clientID]; ok {
+		return overrides
+	}
+	return t
+}
+
+// Render builds the message for the named email.
+func (t *Templates) Render(name, to string, data Data) (*Message, error) {
+	html, ok := t.html[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, body bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return nil, fmt.Errorf("failed to render email subject: %w", err)
+	}
+	if err := t.text.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("failed to render email text: %w", err)
+	}
+	if err := html.ExecuteTemplate(&body, "base", data); err != nil {
+		return nil, fmt.Errorf("failed to render email html: %w", err)
+	}
+
+	return &Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    body.String(),
+	}, nil
+}
@@ -5,4 +5,7 @@ go 1.25.5
 require (
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.46.0
+	golang.org/x/text v0.32.0
 )
+
+require golang.org/x/sys v0.39.0 // indirect
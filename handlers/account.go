@@ -2,130 +2,444 @@ package handlers
 
 import (
 	"context"
-	"html/template"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/yookibooki/auth/apperror"
 	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/i18n"
 	"github.com/yookibooki/auth/middleware"
 	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
 )
 
 type AccountHandlers struct {
-	tmpls          *template.Template
-	userRepo       repo.UserRepo
-	pwdHasher      auth.Hasher
-	emailValidator auth.EmailValidator
+	tmpls           *web.Templates
+	userRepo        repo.UserRepo
+	sessionRepo     repo.SessionRepo
+	emailChanges    repo.EmailChangeTokenRepo
+	txManager       repo.TxManager
+	pwdHasher       auth.Hasher
+	pwdPolicies     *auth.PasswordPolicies
+	pwdHistory      pwdHistory
+	lockout         loginLockout
+	enumerationSafe bool
+	authCodeManager *auth.AuthCodeManager
+	emailTemplates  *email.Templates
+	emailValidator  auth.EmailValidator
+	suppressions    repo.SuppressionRepo
+	locales         *i18n.Bundle
+	baseURL         string
 }
 
 func NewAccountHandlers(
-	tmpls *template.Template,
+	tmpls *web.Templates,
 	userRepo repo.UserRepo,
+	sessionRepo repo.SessionRepo,
+	emailChangeRepo repo.EmailChangeTokenRepo,
+	txManager repo.TxManager,
 	pwdHasher auth.Hasher,
+	pwdPolicies *auth.PasswordPolicies,
+	pwdHistoryRepo repo.PwdHistoryRepo,
+	pwdHistorySize int,
+	lockout auth.LockoutPolicy,
+	enumerationSafe bool,
+	authCodeManager *auth.AuthCodeManager,
+	emailTemplates *email.Templates,
 	emailValidator auth.EmailValidator,
+	suppressions repo.SuppressionRepo,
+	locales *i18n.Bundle,
+	baseURL string,
 ) *AccountHandlers {
 	return &AccountHandlers{
-		tmpls:          tmpls,
-		userRepo:       userRepo,
-		pwdHasher:      pwdHasher,
-		emailValidator: emailValidator,
+		tmpls:        tmpls,
+		userRepo:     userRepo,
+		sessionRepo:  sessionRepo,
+		emailChanges: emailChangeRepo,
+		txManager:    txManager,
+		pwdHasher:    pwdHasher,
+		pwdPolicies:  pwdPolicies,
+		pwdHistory:   pwdHistory{repo: pwdHistoryRepo, pwdHasher: pwdHasher, size: pwdHistorySize},
+		lockout: loginLockout{
+			policy:          lockout,
+			authCodeManager: authCodeManager,
+			txManager:       txManager,
+			emailTemplates:  emailTemplates,
+			baseURL:         baseURL,
+		},
+		enumerationSafe: enumerationSafe,
+		authCodeManager: authCodeManager,
+		emailTemplates:  emailTemplates,
+		emailValidator:  emailValidator,
+		suppressions:    suppressions,
+		locales:         locales,
+		baseURL:         baseURL,
 	}
 }
 
 type AccountPageData struct {
-	Message           string
-	Error             string
-	ChangeEmailURL    string
-	ChangePasswordURL string
-	DeleteAccountURL  string
+	web.Page
+	Message           string        `json:"message,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	ChangeEmailURL    string        `json:"change_email_url,omitempty"`
+	ChangePasswordURL string        `json:"change_password_url,omitempty"`
+	DeleteAccountURL  string        `json:"delete_account_url,omitempty"`
+	ChangeLocaleURL   string        `json:"change_locale_url,omitempty"`
+	ReauthURL         string        `json:"reauth_url,omitempty"`
+	Next              string        `json:"next,omitempty"`
+	EmailSuppressed   bool          `json:"email_suppressed"`
+	Locales           []i18n.Option `json:"locales,omitempty"`
 }
 
 func (h *AccountHandlers) ServeAccount(w http.ResponseWriter, r *http.Request) {
-	data := AccountPageData{
-		ChangeEmailURL:    "/account/email",
-		ChangePasswordURL: "/account/password",
-		DeleteAccountURL:  "/account/delete",
-	}
-	h.tmpls.ExecuteTemplate(w, "account.html", data)
+	h.tmpls.Render(w, r, "account.html", h.accountPageData(r))
 }
 
 func (h *AccountHandlers) HandleChangeEmail(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
 	email := r.FormValue("email")
 
 	if !h.emailValidator.Validate(email) {
-		h.renderAccountError(w, "Invalid email address")
+		h.renderAccountError(w, r, "auth.invalid_email")
 		return
 	}
 
 	ctx := context.Background()
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 
-	if err := h.userRepo.UpdateEmail(ctx, userID, email); err != nil {
-		h.renderAccountError(w, "Failed to update email")
+	owner, err := h.userRepo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to look up user", err))
+		return
+	}
+
+	switch {
+	case err == nil && (owner.ID == userID || !h.enumerationSafe):
+		h.renderAccountError(w, r, "account.update_email_failed")
+		return
+	case err == nil:
+		// Only the owner of the address learns that it is taken.
+		err = h.sendEmailInUse(ctx, owner)
+	default:
+		err = h.sendEmailChangeLink(ctx, r, userID, email)
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to send email", err))
+		return
+	}
+
+	h.tmpls.Render(w, r, "link-sent.html", plainPage(r, h.localizer(r)))
+}
+
+// sendEmailChangeLink mails the new address a link that makes it the
+// account's address, so the change needs access to that mailbox.
+func (h *AccountHandlers) sendEmailChangeLink(ctx context.Context, r *http.Request, userID int, address string) error {
+	tokenData, token, err := h.authCodeManager.CreateEmailChangeToken(userID, address)
+	if err != nil {
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+
+	confirmURL := fmt.Sprintf("%s/account/email/confirm?token=%s", h.baseURL, token)
+	msg, err := h.emailTemplates.Render("change_email", address, email.Data{URL: confirmURL, L: h.localizer(r)})
+	if err != nil {
+		return fmt.Errorf("failed to render email: %w", err)
+	}
+
+	return h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.EmailChanges.Create(ctx, tokenData); err != nil {
+			return err
+		}
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
+}
+
+// HandleConfirmEmail follows the link from the change_email email and
+// applies the change. The link itself proves access to the new address, so
+// no session is needed.
+func (h *AccountHandlers) HandleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	ctx := context.Background()
+	tokenRecord, err := h.emailChanges.FindByTokenHash(ctx, auth.HashToken(token))
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to load token", err))
+		return
+	}
+	if err != nil || tokenRecord.UsedAt.Valid || time.Now().After(tokenRecord.ExpiresAt) {
+		web.Error(w, r, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.EmailChanges.MarkUsed(ctx, tokenRecord.ID); err != nil {
+			return err
+		}
+		return tx.Users.UpdateEmail(ctx, tokenRecord.UserID, tokenRecord.Email)
+	})
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, repo.ErrConflict) {
+		web.Error(w, r, "Email address already in use", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update email", err))
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "link-sent.html", nil)
+	h.tmpls.Render(w, r, "success.html", plainPage(r, localizer(h.locales, r, "")))
+}
+
+// sendEmailInUse tells owner that another account tried to take over their
+// address, in the owner's language.
+func (h *AccountHandlers) sendEmailInUse(ctx context.Context, owner *repo.User) error {
+	loc := h.locales.Negotiate("", owner.Locale, "")
+
+	msg, err := h.emailTemplates.Render("email_in_use", owner.Email, email.Data{URL: h.baseURL + "/reset", L: loc})
+	if err != nil {
+		return fmt.Errorf("failed to render email: %w", err)
+	}
+	return h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
 }
 
 func (h *AccountHandlers) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
 	password := r.FormValue("password")
 
-	if len(password) < 8 {
-		h.renderAccountError(w, "Password must be at least 8 characters")
+	ctx := context.Background()
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, repo.ErrNotFound) {
+		middleware.Unauthorized(w, r)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to load account", err))
+		return
+	}
+
+	session := r.Context().Value(middleware.SessionKey).(*repo.Session)
+	if err := h.pwdPolicies.Check(session.ClientID, password, user.Email); err != nil {
+		var policyErr *auth.PolicyError
+		if !errors.As(err, &policyErr) {
+			web.RenderError(w, r, apperror.Internal("Failed to check password", err))
+			return
+		}
+		h.renderAccountError(w, r, policyErr.Key, policyErr.Args...)
+		return
+	}
+
+	reused, err := h.pwdHistory.isReused(ctx, user, password)
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to check password history", err))
+		return
+	}
+	if reused {
+		h.renderAccountError(w, r, "password.reused")
 		return
 	}
 
 	pwdHash, err := h.pwdHasher.Hash(password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		web.RenderError(w, r, apperror.Internal("Failed to hash password", err))
+		return
+	}
+
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		return h.pwdHistory.replace(ctx, tx, user, pwdHash)
+	})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update password", err))
+		return
+	}
+
+	data := h.accountPageData(r)
+	data.Message = data.L.T("account.password_updated")
+	h.tmpls.Render(w, r, "account.html", data)
+}
+
+func (h *AccountHandlers) HandleChangeLocale(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	locale, ok := h.locales.Supported(r.FormValue("locale"))
+	if !ok {
+		web.Error(w, r, "Unsupported locale", http.StatusBadRequest)
 		return
 	}
 
 	ctx := context.Background()
 	userID := r.Context().Value(middleware.UserIDKey).(int)
 
-	if err := h.userRepo.UpdatePassword(ctx, userID, pwdHash); err != nil {
-		h.renderAccountError(w, "Failed to update password")
+	if err := h.userRepo.UpdateLocale(ctx, userID, locale); err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update locale", err))
 		return
 	}
 
-	data := AccountPageData{
-		Message:           "Password updated successfully",
-		ChangeEmailURL:    "/account/email",
-		ChangePasswordURL: "/account/password",
-		DeleteAccountURL:  "/account/delete",
-	}
-	h.tmpls.ExecuteTemplate(w, "account.html", data)
+	data := h.accountPageData(r)
+	data.Message = data.L.T("account.language_updated")
+	h.tmpls.Render(w, r, "account.html", data)
 }
 
 func (h *AccountHandlers) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 	userID := r.Context().Value(middleware.UserIDKey).(int)
+	loc := h.localizer(r)
 
 	if err := h.userRepo.Delete(ctx, userID); err != nil {
-		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		web.RenderError(w, r, apperror.Internal("Failed to delete account", err))
+		return
+	}
+
+	h.tmpls.Render(w, r, "success.html", plainPage(r, loc))
+}
+
+func (h *AccountHandlers) ServeReauth(w http.ResponseWriter, r *http.Request) {
+	data := AccountPageData{
+		Page:      plainPage(r, h.localizer(r)),
+		ReauthURL: middleware.ReauthPath,
+		Next:      safeNext(r.URL.Query().Get("next")),
+	}
+	h.tmpls.Render(w, r, "reauth.html", data)
+}
+
+func (h *AccountHandlers) HandleReauth(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	password := r.FormValue("password")
+	next := safeNext(r.FormValue("next"))
+
+	ctx := context.Background()
+	session := r.Context().Value(middleware.SessionKey).(*repo.Session)
+
+	user, err := h.userRepo.FindByID(ctx, session.UserID)
+	if errors.Is(err, repo.ErrNotFound) {
+		middleware.Unauthorized(w, r)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to load account", err))
+		return
+	}
+
+	loc := localizer(h.locales, r, user.Locale)
+	// Account pages keep the default look, and so does the unlock email.
+	key, args, err := h.lockout.attempt(ctx, loc, &clients.Client{}, user.ID, "", func(user *repo.User) bool {
+		return h.pwdHasher.Compare(user.PwdHash, password)
+	})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to record login attempt", err))
+		return
+	}
+	if key != "" {
+		h.renderReauthError(w, r, loc, next, key, args...)
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "success.html", nil)
+	if err := h.sessionRepo.MarkReauthenticated(ctx, session.ID); err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update session", err))
+		return
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
-func (h *AccountHandlers) renderAccountError(w http.ResponseWriter, errMsg string) {
+func (h *AccountHandlers) renderReauthError(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, next, key string, args ...any) {
+	if formError(w, r, loc, key, args...) {
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
 	data := AccountPageData{
-		Error:             errMsg,
+		Page:      plainPage(r, loc),
+		Error:     loc.T(key, args...),
+		ReauthURL: middleware.ReauthPath,
+		Next:      next,
+	}
+	h.tmpls.Render(w, r, "reauth.html", data)
+}
+
+func (h *AccountHandlers) renderAccountError(w http.ResponseWriter, r *http.Request, key string, args ...any) {
+	data := h.accountPageData(r)
+	if formError(w, r, data.L, key, args...) {
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	data.Error = data.L.T(key, args...)
+	h.tmpls.Render(w, r, "account.html", data)
+}
+
+// localizer negotiates the page language with the signed-in user's saved
+// preference.
+func (h *AccountHandlers) localizer(r *http.Request) *i18n.Localizer {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	user, err := h.userRepo.FindByID(context.Background(), userID)
+	if err != nil {
+		return localizer(h.locales, r, "")
+	}
+	return localizer(h.locales, r, user.Locale)
+}
+
+// accountPageData flags accounts whose address is on the suppression list,
+// since they will not receive login links or notices until it is changed.
+// A failed lookup only hides the warning.
+func (h *AccountHandlers) accountPageData(r *http.Request) AccountPageData {
+	data := AccountPageData{
+		Page:              plainPage(r, nil),
 		ChangeEmailURL:    "/account/email",
 		ChangePasswordURL: "/account/password",
 		DeleteAccountURL:  "/account/delete",
+		ChangeLocaleURL:   "/account/locale",
+		Locales:           h.locales.Options(),
+	}
+
+	ctx := context.Background()
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		data.L = localizer(h.locales, r, "")
+		return data
+	}
+
+	data.L = localizer(h.locales, r, user.Locale)
+
+	suppressed, err := h.suppressions.IsSuppressed(ctx, user.Email)
+	if err == nil {
+		data.EmailSuppressed = suppressed
+	}
+	return data
+}
+
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/account"
 	}
-	h.tmpls.ExecuteTemplate(w, "account.html", data)
+	return next
 }
@@ -2,115 +2,209 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"html/template"
+	"log"
 	"net/http"
-	"net/url"
+	"strings"
 	"time"
 
+	"github.com/yookibooki/auth/apperror"
 	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/clients"
 	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/pow"
 	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
 )
 
 type AuthHandlers struct {
-	tmpls           *template.Template
+	tmpls           *web.Templates
 	userRepo        repo.UserRepo
 	authCodeRepo    repo.AuthCodeRepo
+	sessionRepo     repo.SessionRepo
+	pwdResetRepo    repo.PwdResetTokenRepo
+	signupTokenRepo repo.SignupTokenRepo
 	pwdHasher       auth.Hasher
+	pwdPolicies     *auth.PasswordPolicies
+	pwdHistory      pwdHistory
+	pwdMaxAge       time.Duration
+	lockout         loginLockout
+	enumerationSafe bool
+	dummyHash       string // compared against for unknown addresses
+	signupNotice    signupNotice
 	authCodeManager *auth.AuthCodeManager
+	sessionManager  *auth.SessionManager
 	emailValidator  auth.EmailValidator
-	emailSender     email.Sender
+	txManager       repo.TxManager
+	emailTemplates  *email.Templates
+	locales         *i18n.Bundle
+	clientRegistry  *clients.Registry
+	puzzle          *pow.Puzzle // nil disables the proof of work
 	baseURL         string
 }
 
 func NewAuthHandlers(
-	tmpls *template.Template,
+	tmpls *web.Templates,
 	userRepo repo.UserRepo,
 	authCodeRepo repo.AuthCodeRepo,
+	sessionRepo repo.SessionRepo,
+	pwdResetRepo repo.PwdResetTokenRepo,
+	signupTokenRepo repo.SignupTokenRepo,
 	pwdHasher auth.Hasher,
+	pwdPolicies *auth.PasswordPolicies,
+	pwdHistoryRepo repo.PwdHistoryRepo,
+	pwdHistorySize int,
+	pwdMaxAge time.Duration,
+	lockout auth.LockoutPolicy,
+	enumerationSafe bool,
 	authCodeManager *auth.AuthCodeManager,
+	sessionManager *auth.SessionManager,
 	emailValidator auth.EmailValidator,
-	emailSender email.Sender,
+	txManager repo.TxManager,
+	emailTemplates *email.Templates,
+	locales *i18n.Bundle,
+	clientRegistry *clients.Registry,
+	puzzle *pow.Puzzle,
 	baseURL string,
 ) *AuthHandlers {
+	// Unknown addresses are compared against a hash with the current
+	// parameters, so they take as long as a wrong password.
+	dummyHash, err := pwdHasher.Hash("enumeration-safe dummy password")
+	if err != nil {
+		log.Printf("Failed to create dummy password hash: %v", err)
+	}
+
 	return &AuthHandlers{
 		tmpls:           tmpls,
 		userRepo:        userRepo,
 		authCodeRepo:    authCodeRepo,
+		sessionRepo:     sessionRepo,
+		pwdResetRepo:    pwdResetRepo,
+		signupTokenRepo: signupTokenRepo,
 		pwdHasher:       pwdHasher,
+		pwdPolicies:     pwdPolicies,
+		pwdHistory:      pwdHistory{repo: pwdHistoryRepo, pwdHasher: pwdHasher, size: pwdHistorySize},
+		pwdMaxAge:       pwdMaxAge,
+		lockout: loginLockout{
+			policy:          lockout,
+			authCodeManager: authCodeManager,
+			txManager:       txManager,
+			emailTemplates:  emailTemplates,
+			baseURL:         baseURL,
+		},
+		enumerationSafe: enumerationSafe,
+		dummyHash:       dummyHash,
+		signupNotice: signupNotice{
+			authCodeManager: authCodeManager,
+			txManager:       txManager,
+			emailTemplates:  emailTemplates,
+			baseURL:         baseURL,
+		},
 		authCodeManager: authCodeManager,
+		sessionManager:  sessionManager,
 		emailValidator:  emailValidator,
-		emailSender:     emailSender,
+		txManager:       txManager,
+		emailTemplates:  emailTemplates,
+		locales:         locales,
+		clientRegistry:  clientRegistry,
+		puzzle:          puzzle,
 		baseURL:         baseURL,
 	}
 }
 
 type AuthPageData struct {
-	Step            string
-	Email           string
-	Error           string
-	PostEmailURL    string
-	PostPasswordURL string
+	web.Page
+	Step                  string `json:"step,omitempty"`
+	Email                 string `json:"email,omitempty"`
+	Error                 string `json:"error,omitempty"`
+	Message               string `json:"message,omitempty"`
+	Token                 string `json:"token,omitempty"`
+	PostEmailURL          string `json:"post_email_url,omitempty"`
+	PostPasswordURL       string `json:"post_password_url,omitempty"`
+	PostChangePasswordURL string `json:"post_change_password_url,omitempty"`
+	PostSignupURL         string `json:"post_signup_url,omitempty"`
 }
 
 func (h *AuthHandlers) ServeAuth(w http.ResponseWriter, r *http.Request) {
 	redirectURI := r.URL.Query().Get("redirect_uri")
 	clientID := r.URL.Query().Get("client_id")
-	state := r.URL.Query().Get("state")
 
 	if redirectURI == "" || clientID == "" {
-		http.Error(w, "Missing required parameters", http.StatusBadRequest)
+		web.Error(w, r, "Missing required parameters", http.StatusBadRequest)
 		return
 	}
 
+	client := h.clientRegistry.Get(clientID)
 	data := AuthPageData{
+		Page:            newPage(r, localizer(h.locales, r, ""), client),
 		Step:            "email",
-		PostEmailURL:    "/auth/email?redirect_uri=" + url.QueryEscape(redirectURI) + "&client_id=" + url.QueryEscape(clientID) + "&state=" + url.QueryEscape(state),
+		PostEmailURL:    "/auth/email?" + flowQuery(r),
 		PostPasswordURL: "/auth/password",
 	}
+	data.Challenge = challenge(r.Context(), h.puzzle)
 
-	h.tmpls.ExecuteTemplate(w, "auth.html", data)
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
 }
 
 func (h *AuthHandlers) HandleEmail(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
 	email := r.FormValue("email")
-	redirectURI := r.URL.Query().Get("redirect_uri")
-	clientID := r.URL.Query().Get("client_id")
-	state := r.URL.Query().Get("state")
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
 
 	if !h.emailValidator.Validate(email) {
-		h.renderAuthError(w, "Invalid email address")
+		loc := localizer(h.locales, r, "")
+		h.renderAuthError(w, r, loc, client, "auth.invalid_email")
 		return
 	}
 
 	ctx := context.Background()
-	_, err := h.userRepo.FindByEmail(ctx, email)
+	if !verifyWork(ctx, h.puzzle, r) {
+		loc := localizer(h.locales, r, "")
+		h.renderAuthError(w, r, loc, client, "auth.pow_failed")
+		return
+	}
 
 	data := AuthPageData{
 		Email:           email,
-		PostPasswordURL: fmt.Sprintf("/auth/password?redirect_uri=%s&client_id=%s&state=%s", url.QueryEscape(redirectURI), url.QueryEscape(clientID), url.QueryEscape(state)),
+		PostPasswordURL: "/auth/password?" + flowQuery(r),
+	}
+	data.Challenge = challenge(ctx, h.puzzle)
+
+	// Whether the address is known only shows after the password step,
+	// and then only to its owner.
+	if h.enumerationSafe {
+		data.Page = newPage(r, localizer(h.locales, r, ""), client)
+		data.Step = "password"
+		h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+		return
 	}
 
-	if err != nil {
+	user, err := h.userRepo.FindByEmail(ctx, email)
+	if errors.Is(err, repo.ErrNotFound) {
+		data.Page = newPage(r, localizer(h.locales, r, ""), client)
 		data.Step = "signup"
-		h.tmpls.ExecuteTemplate(w, "auth.html", data)
+		h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to look up user", err))
 		return
 	}
 
+	data.Page = newPage(r, localizer(h.locales, r, user.Locale), client)
 	data.Step = "password"
-	h.tmpls.ExecuteTemplate(w, "auth.html", data)
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
 }
 
 func (h *AuthHandlers) HandlePassword(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
@@ -119,126 +213,380 @@ func (h *AuthHandlers) HandlePassword(w http.ResponseWriter, r *http.Request) {
 	redirectURI := r.URL.Query().Get("redirect_uri")
 	clientID := r.URL.Query().Get("client_id")
 	state := r.URL.Query().Get("state")
+	client := h.clientRegistry.Get(clientID)
+
+	ctx := context.Background()
 
-	if len(password) < 8 {
-		h.renderAuthError(w, "Password must be at least 8 characters")
+	// Known addresses are not asked for a proof of work in normal mode,
+	// so asking only for unknown ones would give them away.
+	if h.enumerationSafe && !verifyWork(ctx, h.puzzle, r) {
+		loc := localizer(h.locales, r, "")
+		h.renderAuthError(w, r, loc, client, "auth.pow_failed")
 		return
 	}
 
-	ctx := context.Background()
 	user, err := h.userRepo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to look up user", err))
+		return
+	}
 
 	if err == nil {
-		if !h.pwdHasher.Compare(user.PwdHash, password) {
-			h.renderAuthError(w, "Invalid password")
+		loc := localizer(h.locales, r, user.Locale)
+
+		compared := false
+		key, args, err := h.lockout.attempt(ctx, loc, client, user.ID, flowQuery(r), func(user *repo.User) bool {
+			compared = true
+			return h.pwdHasher.Compare(user.PwdHash, password)
+		})
+		if err != nil {
+			web.RenderError(w, r, apperror.Internal("Failed to record login attempt", err))
+			return
+		}
+		if key != "" {
+			if !compared && h.enumerationSafe {
+				h.pwdHasher.Compare(h.dummyHash, password)
+			}
+			h.renderLoginFailure(w, r, loc, client, key, args...)
 			return
 		}
 
-		authCode, code, err := h.authCodeManager.CreateAuthCode(user.ID, clientID, redirectURI, state)
-		if err != nil {
-			http.Error(w, "Failed to create auth code", http.StatusInternalServerError)
+		if h.pwdHasher.NeedsRehash(user.PwdHash) {
+			h.rehashPassword(ctx, user.ID, password)
+		}
+
+		if h.passwordChangeRequired(user) {
+			h.renderChangePassword(w, r, ctx, loc, client, user, flowQuery(r))
 			return
 		}
 
-		if err := h.authCodeRepo.Create(ctx, authCode); err != nil {
-			http.Error(w, "Failed to save auth code", http.StatusInternalServerError)
+		if !h.sendAuthLink(w, r, ctx, loc, user.ID, email, clientID, redirectURI, state, "login") {
 			return
 		}
 
-		confirmURL := fmt.Sprintf("%s/auth/confirm?code=%s", h.baseURL, code)
-		if err := h.sendEmail(email, "Login to your account", fmt.Sprintf("Click here to log in: %s", confirmURL)); err != nil {
-			http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		h.tmpls.For(client.ID).Render(w, r, "link-sent.html", newPage(r, loc, client))
+		return
+	}
+
+	loc := localizer(h.locales, r, "")
+
+	if h.enumerationSafe {
+		h.pwdHasher.Compare(h.dummyHash, password)
+		if err := h.signupNotice.send(ctx, loc, client, email, flowQuery(r)); err != nil {
+			web.RenderError(w, r, apperror.Internal("Failed to send email", err))
 			return
 		}
+		h.renderLoginFailure(w, r, loc, client, "")
+		return
+	}
+
+	if !verifyWork(ctx, h.puzzle, r) {
+		h.renderAuthError(w, r, loc, client, "auth.pow_failed")
+		return
+	}
 
-		h.tmpls.ExecuteTemplate(w, "link-sent.html", nil)
+	if err := h.pwdPolicies.Check(clientID, password, email); err != nil {
+		var policyErr *auth.PolicyError
+		if !errors.As(err, &policyErr) {
+			web.RenderError(w, r, apperror.Internal("Failed to check password", err))
+			return
+		}
+		h.renderAuthError(w, r, loc, client, policyErr.Key, policyErr.Args...)
 		return
 	}
 
 	pwdHash, err := h.pwdHasher.Hash(password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		web.RenderError(w, r, apperror.Internal("Failed to hash password", err))
 		return
 	}
 
-	newUser, err := h.userRepo.Create(ctx, email, pwdHash)
+	// The language the account was created in becomes its preference.
+	newUser, err := h.userRepo.Create(ctx, email, pwdHash, loc.Locale())
+	if errors.Is(err, repo.ErrConflict) {
+		h.renderAuthError(w, r, loc, client, "auth.create_failed")
+		return
+	}
 	if err != nil {
-		h.renderAuthError(w, "Failed to create account")
+		web.RenderError(w, r, apperror.Internal("Failed to create account", err))
 		return
 	}
 
-	authCode, code, err := h.authCodeManager.CreateAuthCode(newUser.ID, clientID, redirectURI, state)
-	if err != nil {
-		http.Error(w, "Failed to create auth code", http.StatusInternalServerError)
+	if !h.sendAuthLink(w, r, ctx, loc, newUser.ID, email, clientID, redirectURI, state, "confirm") {
 		return
 	}
 
-	if err := h.authCodeRepo.Create(ctx, authCode); err != nil {
-		http.Error(w, "Failed to save auth code", http.StatusInternalServerError)
+	h.tmpls.For(client.ID).Render(w, r, "link-sent.html", newPage(r, loc, client))
+}
+
+func (h *AuthHandlers) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
-	confirmURL := fmt.Sprintf("%s/auth/confirm?code=%s", h.baseURL, code)
-	if err := h.sendEmail(email, "Confirm your email", fmt.Sprintf("Click here to confirm: %s", confirmURL)); err != nil {
-		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	clientID := r.URL.Query().Get("client_id")
+	state := r.URL.Query().Get("state")
+	client := h.clientRegistry.Get(clientID)
+
+	ctx := context.Background()
+	tokenRecord, err := h.pwdResetRepo.FindByTokenHash(ctx, auth.HashToken(token))
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to load token", err))
+		return
+	}
+	if err != nil || tokenRecord.UsedAt.Valid || time.Now().After(tokenRecord.ExpiresAt) {
+		loc := localizer(h.locales, r, "")
+		h.renderAuthError(w, r, loc, client, "auth.session_expired")
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "link-sent.html", nil)
-}
+	user, err := h.userRepo.FindByID(ctx, tokenRecord.UserID)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to load account", err))
+		return
+	}
+	if err != nil {
+		loc := localizer(h.locales, r, "")
+		h.renderAuthError(w, r, loc, client, "auth.session_expired")
+		return
+	}
 
-func (h *AuthHandlers) HandleConfirm(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Missing code", http.StatusBadRequest)
+	loc := localizer(h.locales, r, user.Locale)
+
+	if err := h.pwdPolicies.Check(clientID, password, user.Email); err != nil {
+		var policyErr *auth.PolicyError
+		if !errors.As(err, &policyErr) {
+			web.RenderError(w, r, apperror.Internal("Failed to check password", err))
+			return
+		}
+		h.renderChangePasswordStep(w, r, loc, client, user.Email, token, flowQuery(r), policyErr.Key, policyErr.Args...)
 		return
 	}
 
-	codeBytes, err := base64.URLEncoding.DecodeString(code)
+	reused, err := h.pwdHistory.isReused(ctx, user, password)
 	if err != nil {
-		http.Error(w, "Invalid code", http.StatusBadRequest)
+		web.RenderError(w, r, apperror.Internal("Failed to check password history", err))
+		return
+	}
+	if reused {
+		h.renderChangePasswordStep(w, r, loc, client, user.Email, token, flowQuery(r), "password.reused")
 		return
 	}
 
-	codeHash, err := h.pwdHasher.Hash(string(codeBytes))
+	pwdHash, err := h.pwdHasher.Hash(password)
 	if err != nil {
-		http.Error(w, "Invalid code", http.StatusBadRequest)
+		web.RenderError(w, r, apperror.Internal("Failed to hash password", err))
+		return
+	}
+
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.PwdResetTokens.MarkUsed(ctx, tokenRecord.ID); err != nil {
+			return err
+		}
+		return h.pwdHistory.replace(ctx, tx, user, pwdHash)
+	})
+	if errors.Is(err, repo.ErrNotFound) {
+		h.renderAuthError(w, r, loc, client, "auth.session_expired")
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update password", err))
+		return
+	}
+
+	if !h.sendAuthLink(w, r, ctx, loc, user.ID, user.Email, clientID, redirectURI, state, "login") {
+		return
+	}
+
+	h.tmpls.For(client.ID).Render(w, r, "link-sent.html", newPage(r, loc, client))
+}
+
+func (h *AuthHandlers) HandleConfirm(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		web.Error(w, r, "Missing code", http.StatusBadRequest)
 		return
 	}
 
 	ctx := context.Background()
-	codeRecord, err := h.authCodeRepo.FindByCodeHash(ctx, codeHash)
+	codeRecord, err := h.authCodeRepo.FindByCodeHash(ctx, auth.HashToken(code))
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		web.RenderError(w, r, apperror.Internal("Failed to load code", err))
 		return
 	}
 
 	if codeRecord.UsedAt.Valid {
-		http.Error(w, "Code already used", http.StatusBadRequest)
+		web.Error(w, r, "Code already used", http.StatusBadRequest)
 		return
 	}
 
 	if time.Now().After(codeRecord.ExpiresAt) {
-		http.Error(w, "Code expired", http.StatusBadRequest)
+		web.Error(w, r, "Code expired", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.authCodeRepo.MarkUsed(ctx, codeRecord.ID); err != nil {
-		http.Error(w, "Failed to mark code as used", http.StatusInternalServerError)
+	err = h.authCodeRepo.MarkUsed(ctx, codeRecord.ID)
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Code already used", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to mark code as used", err))
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "success.html", nil)
+	if !h.startSession(w, r, ctx, codeRecord.UserID, codeRecord.ClientID) {
+		return
+	}
+
+	client := h.clientRegistry.Get(codeRecord.ClientID)
+	h.tmpls.For(client.ID).Render(w, r, "success.html", newPage(r, localizer(h.locales, r, ""), client))
+}
+
+// startSession logs the user in through clientID by setting the session
+// cookie. It writes an error response and returns false on failure.
+func (h *AuthHandlers) startSession(w http.ResponseWriter, r *http.Request, ctx context.Context, userID int, clientID string) bool {
+	session, token, err := h.sessionManager.CreateSession(userID, clientID)
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to create session", err))
+		return false
+	}
+
+	if err := h.sessionRepo.Create(ctx, session); err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to save session", err))
+		return false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(h.sessionManager.TTL().Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true
 }
 
-func (h *AuthHandlers) renderAuthError(w http.ResponseWriter, errMsg string) {
+// renderLoginFailure shows why a password was not accepted. In
+// enumeration-safe mode every failure, including an unknown address, shows
+// the same message in the language of the request rather than the user's.
+func (h *AuthHandlers) renderLoginFailure(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, client *clients.Client, key string, args ...any) {
+	if h.enumerationSafe {
+		loc = localizer(h.locales, r, "")
+		key, args = "auth.invalid_credentials", nil
+	}
+	h.renderAuthError(w, r, loc, client, key, args...)
+}
+
+func (h *AuthHandlers) renderAuthError(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, client *clients.Client, key string, args ...any) {
+	if formError(w, r, loc, key, args...) {
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
 	data := AuthPageData{
+		Page:  newPage(r, loc, client),
 		Step:  "email",
-		Error: errMsg,
+		Error: loc.T(key, args...),
+	}
+	data.Challenge = challenge(context.Background(), h.puzzle)
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+}
+
+func (h *AuthHandlers) passwordChangeRequired(user *repo.User) bool {
+	if user.MustChangePassword {
+		return true
 	}
-	h.tmpls.ExecuteTemplate(w, "auth.html", data)
+	return h.pwdMaxAge > 0 && time.Since(user.PwdChangedAt) > h.pwdMaxAge
 }
 
-func (h *AuthHandlers) sendEmail(to, subject, body string) error {
-	return h.emailSender.Send(to, subject, body)
+// renderChangePassword issues a token proving the current password was just
+// verified and asks the user for a new one.
+func (h *AuthHandlers) renderChangePassword(w http.ResponseWriter, r *http.Request, ctx context.Context, loc *i18n.Localizer, client *clients.Client, user *repo.User, query string) {
+	tokenData, token, err := h.authCodeManager.CreatePwdChangeToken(user.ID)
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to create token", err))
+		return
+	}
+
+	if err := h.pwdResetRepo.Create(ctx, tokenData); err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to save token", err))
+		return
+	}
+
+	h.renderChangePasswordStep(w, r, loc, client, user.Email, token, query, "")
+}
+
+func (h *AuthHandlers) renderChangePasswordStep(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, client *clients.Client, email, token, query, errKey string, errArgs ...any) {
+	if formError(w, r, loc, errKey, errArgs...) {
+		return
+	}
+	if errKey != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	data := AuthPageData{
+		Page:                  newPage(r, loc, client),
+		Step:                  "change_password",
+		Email:                 email,
+		Error:                 loc.T(errKey, errArgs...),
+		Message:               loc.T("auth.change_password_required"),
+		Token:                 token,
+		PostChangePasswordURL: "/auth/password/change?" + query,
+	}
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+}
+
+// sendAuthLink stores a new auth code and queues the email carrying its
+// confirmation link in the same transaction. It writes an error response and
+// returns false on failure.
+func (h *AuthHandlers) sendAuthLink(w http.ResponseWriter, r *http.Request, ctx context.Context, loc *i18n.Localizer, userID int, to, clientID, redirectURI, state, template string) bool {
+	authCode, code, err := h.authCodeManager.CreateAuthCode(userID, clientID, redirectURI, state)
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to create auth code", err))
+		return false
+	}
+
+	confirmURL := fmt.Sprintf("%s/auth/confirm?code=%s", h.baseURL, code)
+	client := h.clientRegistry.Get(clientID)
+	msg, err := h.emailTemplates.For(client.ID).Render(template, to, email.Data{URL: confirmURL, L: loc, Brand: client.Branding})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to render email", err))
+		return false
+	}
+
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.AuthCodes.Create(ctx, authCode); err != nil {
+			return err
+		}
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to save auth code", err))
+		return false
+	}
+
+	return true
+}
+
+func (h *AuthHandlers) rehashPassword(ctx context.Context, userID int, password string) {
+	pwdHash, err := h.pwdHasher.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", userID, err)
+		return
+	}
+
+	if err := h.userRepo.RehashPassword(ctx, userID, pwdHash); err != nil {
+		log.Printf("Failed to store rehashed password for user %d: %v", userID, err)
+	}
 }
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/web"
+)
+
+type BounceHandlers struct {
+	suppressions email.Suppressions
+	secret       string
+}
+
+func NewBounceHandlers(suppressions email.Suppressions, secret string) *BounceHandlers {
+	return &BounceHandlers{suppressions: suppressions, secret: secret}
+}
+
+// HandleWebhook ingests bounce and complaint events from a mail provider.
+// The body is a single event or an array of events:
+//
+//	{"type": "bounce", "email": "a@example.com", "permanent": true, "detail": "5.1.1 user unknown"}
+//
+// Requests must carry the shared secret as a bearer token.
+func (h *BounceHandlers) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r, h.secret) {
+		web.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		web.Error(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var events []email.Bounce
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		err = json.Unmarshal(body, &events)
+	} else {
+		var event email.Bounce
+		err = json.Unmarshal(body, &event)
+		events = append(events, event)
+	}
+	if err != nil {
+		web.Error(w, r, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		if event.Email == "" || (event.Type != email.SuppressBounce && event.Type != email.SuppressComplaint) {
+			web.Error(w, r, "Each event needs an email and a type of bounce or complaint", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := context.Background()
+	for _, event := range events {
+		if err := event.Record(ctx, h.suppressions); err != nil {
+			web.RenderError(w, r, apperror.Internal("Failed to record event", err))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/web"
+)
+
+// DevMailHandlers serve a browsable inbox for the development mail sink.
+// They must only be mounted when EMAIL_DRIVER=dev.
+type DevMailHandlers struct {
+	tmpls  *web.Templates
+	sender *email.DevSender
+}
+
+func NewDevMailHandlers(tmpls *web.Templates, sender *email.DevSender) *DevMailHandlers {
+	return &DevMailHandlers{
+		tmpls:  tmpls,
+		sender: sender,
+	}
+}
+
+type DevMailPageData struct {
+	web.Page
+	Messages []email.StoredMessage
+	Message  *email.StoredMessage
+	TextHTML template.HTML
+	HTMLURL  string
+}
+
+func (h *DevMailHandlers) ServeInbox(w http.ResponseWriter, r *http.Request) {
+	data := DevMailPageData{
+		Page:     plainPage(r, nil),
+		Messages: h.sender.Messages(),
+	}
+	h.tmpls.ExecuteTemplate(w, "dev-mail.html", data)
+}
+
+func (h *DevMailHandlers) ServeMessage(w http.ResponseWriter, r *http.Request) {
+	msg, ok := h.sender.Message(r.PathValue("id"))
+	if !ok {
+		web.Error(w, r, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	data := DevMailPageData{
+		Page:     plainPage(r, nil),
+		Message:  &msg,
+		TextHTML: linkify(msg.Text),
+		HTMLURL:  "/_dev/mail/" + msg.ID + "/html",
+	}
+	h.tmpls.ExecuteTemplate(w, "dev-mail.html", data)
+}
+
+// ServeMessageHTML serves the HTML part on its own so it can be framed.
+// Links open in the top window instead of the frame.
+func (h *DevMailHandlers) ServeMessageHTML(w http.ResponseWriter, r *http.Request) {
+	msg, ok := h.sender.Message(r.PathValue("id"))
+	if !ok {
+		web.Error(w, r, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// Emails are styled inline and framed by ServeMessage.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src * data:; frame-ancestors 'self'")
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(`<base target="_top">` + msg.HTML))
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+func linkify(text string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		link := template.HTMLEscapeString(text[loc[0]:loc[1]])
+		b.WriteString(`<a href="` + link + `">` + link + `</a>`)
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerAuthorized reports whether r carries secret as its bearer token.
+// The internal endpoints are called by other services with a shared secret.
+func bearerAuthorized(r *http.Request, secret string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
+)
+
+// loginLockout slows down password guessing against a single account, see
+// auth.LockoutPolicy. Login and re-authentication share it, so a stolen
+// session is no way around it.
+type loginLockout struct {
+	policy          auth.LockoutPolicy
+	authCodeManager *auth.AuthCodeManager
+	txManager       repo.TxManager
+	emailTemplates  *email.Templates
+	baseURL         string
+}
+
+// check explains why user may not try a password right now, as a message
+// key and its arguments, or returns "" if they may.
+func (l loginLockout) check(user *repo.User) (string, []any) {
+	now := time.Now()
+
+	if user.LockedUntil.Valid && now.Before(user.LockedUntil.Time) {
+		return "auth.locked", nil
+	}
+
+	if user.LastFailedLoginAt.Valid {
+		next := user.LastFailedLoginAt.Time.Add(l.policy.Delay(user.FailedLogins))
+		if wait := next.Sub(now); wait > 0 {
+			return "auth.too_fast", []any{int(math.Ceil(wait.Seconds()))}
+		}
+	}
+
+	return "", nil
+}
+
+// attempt judges a password for the account userID. verify compares it
+// with the hash of the freshly loaded user. The account row stays locked
+// meanwhile, so concurrent guesses are judged one after another and each
+// sees the delay and lock left by the previous one.
+//
+// A wrong password is counted; the failure that reaches the lockout
+// threshold locks the account and emails its owner an unlock link carrying
+// query. attempt returns "" for a right password, and otherwise the message
+// key and arguments to show. verify is not called while the account is
+// locked or the delay has not passed.
+func (l loginLockout) attempt(ctx context.Context, loc *i18n.Localizer, client *clients.Client, userID int, query string, verify func(user *repo.User) bool) (string, []any, error) {
+	var key string
+	var args []any
+	err := l.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		user, err := tx.Users.FindByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if key, args = l.check(user); key != "" {
+			return nil
+		}
+
+		if verify(user) {
+			if user.FailedLogins == 0 && !user.LockedUntil.Valid {
+				return nil
+			}
+			return tx.Users.ResetFailedLogins(ctx, user.ID)
+		}
+
+		key, err = l.recordFailure(ctx, tx, loc, client, user, query)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return key, args, nil
+}
+
+// recordFailure counts a wrong password for user and, when that reaches the
+// lockout threshold, locks the account and queues the unlock email. It
+// returns the message key to show.
+func (l loginLockout) recordFailure(ctx context.Context, tx *repo.Tx, loc *i18n.Localizer, client *clients.Client, user *repo.User, query string) (string, error) {
+	failures, err := tx.Users.RecordFailedLogin(ctx, user.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to record login attempt: %w", err)
+	}
+
+	lockFor := l.policy.LockFor(failures)
+	if lockFor == 0 {
+		return "auth.invalid_password", nil
+	}
+
+	token, tokenHash, err := l.authCodeManager.CreateUnlockToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to create unlock token: %w", err)
+	}
+
+	unlockURL := fmt.Sprintf("%s/auth/unlock?token=%s", l.baseURL, token)
+	if query != "" {
+		unlockURL += "&" + query
+	}
+	msg, err := l.emailTemplates.For(client.ID).Render("unlock", user.Email, email.Data{URL: unlockURL, L: loc, Brand: client.Branding})
+	if err != nil {
+		return "", fmt.Errorf("failed to render email: %w", err)
+	}
+
+	if err := tx.Users.Lock(ctx, user.ID, time.Now().Add(lockFor), tokenHash); err != nil {
+		return "", fmt.Errorf("failed to lock account: %w", err)
+	}
+	if err := tx.Outbox.Enqueue(ctx, msg); err != nil {
+		return "", fmt.Errorf("failed to queue unlock email: %w", err)
+	}
+
+	log.Printf("Locked user %d for %v after %d failed logins", user.ID, lockFor, failures)
+	return "auth.locked", nil
+}
+
+// HandleUnlock follows the link from the lockout email and lets the owner
+// log in again right away.
+func (h *AuthHandlers) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
+	loc := localizer(h.locales, r, "")
+
+	_, err := h.userRepo.Unlock(context.Background(), auth.HashToken(token))
+	if errors.Is(err, repo.ErrNotFound) {
+		h.renderAuthError(w, r, loc, client, "auth.unlock_invalid")
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to unlock account", err))
+		return
+	}
+
+	data := AuthPageData{
+		Page:            newPage(r, loc, client),
+		Step:            "email",
+		Message:         loc.T("auth.unlocked"),
+		PostEmailURL:    "/auth/email?" + flowQuery(r),
+		PostPasswordURL: "/auth/password",
+	}
+	data.Challenge = challenge(r.Context(), h.puzzle)
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+}
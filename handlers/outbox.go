@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/web"
+)
+
+type OutboxHandlers struct {
+	worker *email.Worker
+	secret string
+}
+
+func NewOutboxHandlers(worker *email.Worker, secret string) *OutboxHandlers {
+	return &OutboxHandlers{worker: worker, secret: secret}
+}
+
+// HandleStats reports the email outbox queue depth as JSON. Requests must
+// carry the shared secret as a bearer token.
+func (h *OutboxHandlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r, h.secret) {
+		web.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	depth, err := h.worker.Depth(context.Background())
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to read outbox", err))
+		return
+	}
+
+	web.WriteJSON(w, http.StatusOK, depth)
+}
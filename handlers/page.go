@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/middleware"
+	"github.com/yookibooki/auth/web"
+)
+
+// newPage builds the data every page embeds, branded for client.
+func newPage(r *http.Request, loc *i18n.Localizer, client *clients.Client) web.Page {
+	page := plainPage(r, loc)
+	page.Brand = client.Branding
+	return page
+}
+
+// plainPage is newPage for pages outside a client's flow, which keep the
+// default look.
+func plainPage(r *http.Request, loc *i18n.Localizer) web.Page {
+	csrfToken, _ := r.Context().Value(middleware.CSRFTokenKey).(string)
+	cspNonce, _ := r.Context().Value(middleware.CSPNonceKey).(string)
+	return web.Page{L: loc, CSRFToken: csrfToken, CSPNonce: cspNonce}
+}
+
+// formError answers a JSON request whose form was rejected for errKey and
+// returns true. Pages show the error in the form instead, so for them, as
+// without an error, it returns false.
+func formError(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, errKey string, errArgs ...any) bool {
+	if errKey == "" || !web.WantsJSON(r) {
+		return false
+	}
+	web.RenderError(w, r, &apperror.Error{
+		Status:  http.StatusBadRequest,
+		Code:    errKey,
+		Message: loc.T(errKey, errArgs...),
+	})
+	return true
+}
+
+// localizer picks the language of a response: an explicit ui_locales
+// parameter, then the user's saved preference, then Accept-Language.
+func localizer(locales *i18n.Bundle, r *http.Request, preferred string) *i18n.Localizer {
+	return locales.Negotiate(r.FormValue("ui_locales"), preferred, r.Header.Get("Accept-Language"))
+}
+
+// flowQuery carries the authorization request parameters, including
+// ui_locales, from one auth step to the next.
+func flowQuery(r *http.Request) string {
+	q := r.URL.Query()
+	v := url.Values{}
+	v.Set("redirect_uri", q.Get("redirect_uri"))
+	v.Set("client_id", q.Get("client_id"))
+	v.Set("state", q.Get("state"))
+	if uiLocales := q.Get("ui_locales"); uiLocales != "" {
+		v.Set("ui_locales", uiLocales)
+	}
+	return v.Encode()
+}
@@ -2,154 +2,313 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"html/template"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/yookibooki/auth/apperror"
 	"github.com/yookibooki/auth/auth"
-	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/clients"
+	emailpkg "github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/pow"
 	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
 )
 
 type PwdResetHandlers struct {
-	tmpls        *template.Template
-	userRepo     repo.UserRepo
-	pwdResetRepo repo.PwdResetTokenRepo
-	pwdHasher    auth.Hasher
-	authCodeMgr  *auth.AuthCodeManager
-	emailSender  email.Sender
-	baseURL      string
+	tmpls           *web.Templates
+	userRepo        repo.UserRepo
+	pwdResetRepo    repo.PwdResetTokenRepo
+	pwdHasher       auth.Hasher
+	pwdPolicies     *auth.PasswordPolicies
+	pwdHistory      pwdHistory
+	enumerationSafe bool
+	signupNotice    signupNotice
+	authCodeMgr     *auth.AuthCodeManager
+	txManager       repo.TxManager
+	emailTemplates  *emailpkg.Templates
+	locales         *i18n.Bundle
+	clientRegistry  *clients.Registry
+	puzzle          *pow.Puzzle // nil disables the proof of work
+	baseURL         string
 }
 
 func NewPwdResetHandlers(
-	tmpls *template.Template,
+	tmpls *web.Templates,
 	userRepo repo.UserRepo,
 	pwdResetRepo repo.PwdResetTokenRepo,
 	pwdHasher auth.Hasher,
+	pwdPolicies *auth.PasswordPolicies,
+	pwdHistoryRepo repo.PwdHistoryRepo,
+	pwdHistorySize int,
+	enumerationSafe bool,
 	authCodeMgr *auth.AuthCodeManager,
-	emailSender email.Sender,
+	txManager repo.TxManager,
+	emailTemplates *emailpkg.Templates,
+	locales *i18n.Bundle,
+	clientRegistry *clients.Registry,
+	puzzle *pow.Puzzle,
 	baseURL string,
 ) *PwdResetHandlers {
 	return &PwdResetHandlers{
-		tmpls:        tmpls,
-		userRepo:     userRepo,
-		pwdResetRepo: pwdResetRepo,
-		pwdHasher:    pwdHasher,
-		authCodeMgr:  authCodeMgr,
-		emailSender:  emailSender,
-		baseURL:      baseURL,
+		tmpls:           tmpls,
+		userRepo:        userRepo,
+		pwdResetRepo:    pwdResetRepo,
+		pwdHasher:       pwdHasher,
+		pwdPolicies:     pwdPolicies,
+		pwdHistory:      pwdHistory{repo: pwdHistoryRepo, pwdHasher: pwdHasher, size: pwdHistorySize},
+		enumerationSafe: enumerationSafe,
+		signupNotice: signupNotice{
+			authCodeManager: authCodeMgr,
+			txManager:       txManager,
+			emailTemplates:  emailTemplates,
+			baseURL:         baseURL,
+		},
+		authCodeMgr:    authCodeMgr,
+		txManager:      txManager,
+		emailTemplates: emailTemplates,
+		locales:        locales,
+		clientRegistry: clientRegistry,
+		puzzle:         puzzle,
+		baseURL:        baseURL,
 	}
 }
 
+type ResetPageData struct {
+	web.Page
+	Action          string `json:"action,omitempty"` // "" (request a link) | "complete"
+	Token           string `json:"token,omitempty"`
+	Error           string `json:"error,omitempty"`
+	PostRequestURL  string `json:"post_request_url,omitempty"`
+	PostCompleteURL string `json:"post_complete_url,omitempty"`
+}
+
+// The reset flow optionally carries a client_id in every URL, including the
+// emailed link, so each step shows that client's branding.
 func (h *PwdResetHandlers) ServeReset(w http.ResponseWriter, r *http.Request) {
-	h.tmpls.ExecuteTemplate(w, "reset.html", nil)
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
+	h.renderRequest(w, r, r.Context(), localizer(h.locales, r, ""), client, "")
 }
 
 func (h *PwdResetHandlers) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
 	email := r.FormValue("email")
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
 
 	ctx := context.Background()
+	if !verifyWork(ctx, h.puzzle, r) {
+		loc := localizer(h.locales, r, "")
+		h.renderRequest(w, r, ctx, loc, client, "auth.pow_failed")
+		return
+	}
+
 	user, err := h.userRepo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to look up user", err))
+		return
+	}
 	if err != nil {
-		h.tmpls.ExecuteTemplate(w, "link-sent.html", nil)
+		loc := localizer(h.locales, r, "")
+		// Like a reset link, the notice mints a SHA-256 token and queues
+		// an email with it in one transaction, so both take as long.
+		if h.enumerationSafe {
+			if err := h.signupNotice.send(ctx, loc, client, email, "client_id="+url.QueryEscape(client.ID)); err != nil {
+				web.RenderError(w, r, apperror.Internal("Failed to send email", err))
+				return
+			}
+		}
+		h.tmpls.For(client.ID).Render(w, r, "link-sent.html", newPage(r, loc, client))
 		return
 	}
 
+	// In enumeration-safe mode the page must not reveal the language of
+	// the account, only the email does.
+	pageLoc := localizer(h.locales, r, "")
+	loc := localizer(h.locales, r, user.Locale)
+	if !h.enumerationSafe {
+		pageLoc = loc
+	}
+
 	tokenData, plainToken, err := h.authCodeMgr.CreatePwdResetToken(user.ID)
 	if err != nil {
-		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		web.RenderError(w, r, apperror.Internal("Failed to create token", err))
 		return
 	}
 
-	if err := h.pwdResetRepo.Create(ctx, tokenData); err != nil {
-		http.Error(w, "Failed to save token", http.StatusInternalServerError)
+	resetURL := fmt.Sprintf("%s/reset/confirm?token=%s&client_id=%s", h.baseURL, plainToken, url.QueryEscape(client.ID))
+	msg, err := h.emailTemplates.For(client.ID).Render("reset", email, emailpkg.Data{URL: resetURL, L: loc, Brand: client.Branding})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to render email", err))
 		return
 	}
 
-	resetURL := fmt.Sprintf("%s/reset/confirm?token=%s", h.baseURL, plainToken)
-	if err := h.emailSender.Send(email, "Reset your password", fmt.Sprintf("Click here to reset: %s", resetURL)); err != nil {
-		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.PwdResetTokens.Create(ctx, tokenData); err != nil {
+			return err
+		}
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to save token", err))
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "link-sent.html", nil)
+	h.tmpls.For(client.ID).Render(w, r, "link-sent.html", newPage(r, pageLoc, client))
 }
 
 func (h *PwdResetHandlers) HandleConfirm(w http.ResponseWriter, r *http.Request) {
-	tokenHash := r.URL.Query().Get("token")
-	if tokenHash == "" {
-		http.Error(w, "Missing token", http.StatusBadRequest)
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		web.Error(w, r, "Missing token", http.StatusBadRequest)
 		return
 	}
 
 	ctx := context.Background()
-	tokenRecord, err := h.pwdResetRepo.FindByTokenHash(ctx, tokenHash)
+	tokenRecord, err := h.pwdResetRepo.FindByTokenHash(ctx, auth.HashToken(token))
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		web.RenderError(w, r, apperror.Internal("Failed to load token", err))
 		return
 	}
 
 	if tokenRecord.UsedAt.Valid {
-		http.Error(w, "Token already used", http.StatusBadRequest)
+		web.Error(w, r, "Token already used", http.StatusBadRequest)
 		return
 	}
 
 	if time.Now().After(tokenRecord.ExpiresAt) {
-		http.Error(w, "Token expired", http.StatusBadRequest)
+		web.Error(w, r, "Token expired", http.StatusBadRequest)
 		return
 	}
 
-	h.tmpls.ExecuteTemplate(w, "reset.html", struct {
-		Token  string
-		Action string
-	}{
-		Token:  tokenHash,
-		Action: "complete",
-	})
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
+	data := ResetPageData{
+		Page:            newPage(r, localizer(h.locales, r, ""), client),
+		Action:          "complete",
+		Token:           token,
+		PostCompleteURL: "/reset/complete?client_id=" + url.QueryEscape(client.ID),
+	}
+	h.tmpls.For(client.ID).Render(w, r, "reset.html", data)
 }
 
 func (h *PwdResetHandlers) HandleComplete(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form", http.StatusBadRequest)
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
 		return
 	}
 
-	tokenHash := r.FormValue("token")
+	token := r.FormValue("token")
 	password := r.FormValue("password")
 
-	if len(password) < 8 {
-		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+	ctx := context.Background()
+	tokenRecord, err := h.pwdResetRepo.FindByTokenHash(ctx, auth.HashToken(token))
+	if err != nil && !errors.Is(err, repo.ErrNotFound) {
+		web.RenderError(w, r, apperror.Internal("Failed to load token", err))
+		return
+	}
+	if err != nil || tokenRecord.UsedAt.Valid || time.Now().After(tokenRecord.ExpiresAt) {
+		web.Error(w, r, "Invalid or expired token", http.StatusBadRequest)
 		return
 	}
 
-	ctx := context.Background()
-	tokenRecord, err := h.pwdResetRepo.FindByTokenHash(ctx, tokenHash)
+	user, err := h.userRepo.FindByID(ctx, tokenRecord.UserID)
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Invalid token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to load account", err))
+		return
+	}
+
+	loc := localizer(h.locales, r, user.Locale)
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
+
+	if err := h.pwdPolicies.Check(client.ID, password, user.Email); err != nil {
+		var policyErr *auth.PolicyError
+		if !errors.As(err, &policyErr) {
+			web.RenderError(w, r, apperror.Internal("Failed to check password", err))
+			return
+		}
+		h.renderCompleteError(w, r, loc, client, token, policyErr.Key, policyErr.Args...)
+		return
+	}
+
+	reused, err := h.pwdHistory.isReused(ctx, user, password)
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusBadRequest)
+		web.RenderError(w, r, apperror.Internal("Failed to check password history", err))
+		return
+	}
+	if reused {
+		h.renderCompleteError(w, r, loc, client, token, "password.reused")
 		return
 	}
 
 	pwdHash, err := h.pwdHasher.Hash(password)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		web.RenderError(w, r, apperror.Internal("Failed to hash password", err))
 		return
 	}
 
-	if err := h.userRepo.UpdatePassword(ctx, tokenRecord.UserID, pwdHash); err != nil {
-		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.PwdResetTokens.MarkUsed(ctx, tokenRecord.ID); err != nil {
+			return err
+		}
+		return h.pwdHistory.replace(ctx, tx, user, pwdHash)
+	})
+	if errors.Is(err, repo.ErrNotFound) {
+		web.Error(w, r, "Invalid or expired token", http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to update password", err))
+		return
+	}
+
+	h.tmpls.For(client.ID).Render(w, r, "success.html", newPage(r, loc, client))
+}
 
-	if err := h.pwdResetRepo.MarkUsed(ctx, tokenRecord.ID); err != nil {
-		http.Error(w, "Failed to mark token as used", http.StatusInternalServerError)
+// renderRequest shows the form asking for the email address, with errKey
+// explaining why the last one was rejected.
+func (h *PwdResetHandlers) renderRequest(w http.ResponseWriter, r *http.Request, ctx context.Context, loc *i18n.Localizer, client *clients.Client, errKey string) {
+	if formError(w, r, loc, errKey) {
 		return
 	}
+	if errKey != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
 
-	h.tmpls.ExecuteTemplate(w, "success.html", nil)
+	data := ResetPageData{
+		Page:           newPage(r, loc, client),
+		Error:          loc.T(errKey),
+		PostRequestURL: "/reset/request?client_id=" + url.QueryEscape(client.ID),
+	}
+	data.Challenge = challenge(ctx, h.puzzle)
+	h.tmpls.For(client.ID).Render(w, r, "reset.html", data)
+}
+
+func (h *PwdResetHandlers) renderCompleteError(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, client *clients.Client, token, key string, args ...any) {
+	if formError(w, r, loc, key, args...) {
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	data := ResetPageData{
+		Page:            newPage(r, loc, client),
+		Action:          "complete",
+		Token:           token,
+		Error:           loc.T(key, args...),
+		PostCompleteURL: "/reset/complete?client_id=" + url.QueryEscape(client.ID),
+	}
+	h.tmpls.For(client.ID).Render(w, r, "reset.html", data)
 }
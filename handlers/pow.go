@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yookibooki/auth/pow"
+)
+
+// challenge issues the proof-of-work challenge for a protected form, or
+// none when the puzzle is disabled.
+func challenge(ctx context.Context, puzzle *pow.Puzzle) pow.Challenge {
+	if puzzle == nil {
+		return pow.Challenge{}
+	}
+
+	c, err := puzzle.Issue(ctx)
+	if err != nil {
+		log.Printf("Failed to issue proof of work challenge: %v", err)
+	}
+	return c
+}
+
+// verifyWork checks the proof of work posted with a protected form. Like
+// the rate limiter it fails open when the store is unavailable.
+func verifyWork(ctx context.Context, puzzle *pow.Puzzle, r *http.Request) bool {
+	if puzzle == nil {
+		return true
+	}
+
+	err := puzzle.Verify(ctx, r.FormValue("pow_token"), r.FormValue("pow_nonce"))
+	if err != nil && !errors.Is(err, pow.ErrInvalid) && !errors.Is(err, pow.ErrExpired) && !errors.Is(err, pow.ErrSpent) {
+		log.Printf("Failed to verify proof of work: %v", err)
+		return true
+	}
+	return err == nil
+}
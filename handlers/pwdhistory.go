@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/repo"
+)
+
+// pwdHistory rejects reuse of the current password and the previous size-1
+// ones. A size of zero disables the check.
+type pwdHistory struct {
+	repo      repo.PwdHistoryRepo
+	pwdHasher auth.Hasher
+	size      int
+}
+
+func (p pwdHistory) isReused(ctx context.Context, user *repo.User, password string) (bool, error) {
+	if p.size <= 0 {
+		return false, nil
+	}
+
+	if p.pwdHasher.Compare(user.PwdHash, password) {
+		return true, nil
+	}
+
+	if p.size == 1 {
+		return false, nil
+	}
+
+	hashes, err := p.repo.ListRecent(ctx, user.ID, p.size-1)
+	if err != nil {
+		return false, err
+	}
+
+	for _, hash := range hashes {
+		if p.pwdHasher.Compare(hash, password) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// replace sets the password of user to pwdHash and archives the hash being
+// replaced, both within tx, so a failed update leaves no history behind.
+func (p pwdHistory) replace(ctx context.Context, tx *repo.Tx, user *repo.User, pwdHash string) error {
+	if p.size > 1 {
+		if err := tx.PwdHistory.Add(ctx, user.ID, user.PwdHash); err != nil {
+			return err
+		}
+		if err := tx.PwdHistory.Prune(ctx, user.ID, p.size-1); err != nil {
+			return err
+		}
+	}
+	return tx.Users.UpdatePassword(ctx, user.ID, pwdHash)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/auth"
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/email"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
+)
+
+// signupNotice tells an address without an account that someone tried to
+// use it, with a link to sign up. In enumeration-safe mode it replaces the
+// signup step, so that only the owner of the address learns it is unknown.
+type signupNotice struct {
+	authCodeManager *auth.AuthCodeManager
+	txManager       repo.TxManager
+	emailTemplates  *email.Templates
+	baseURL         string
+}
+
+// send queues the notice. query carries the auth flow parameters into the
+// signup link.
+func (n signupNotice) send(ctx context.Context, loc *i18n.Localizer, client *clients.Client, to, query string) error {
+	tokenData, token, err := n.authCodeManager.CreateSignupToken(to)
+	if err != nil {
+		return err
+	}
+
+	signupURL := fmt.Sprintf("%s/auth/signup?token=%s&%s", n.baseURL, token, query)
+	msg, err := n.emailTemplates.For(client.ID).Render("signin_attempt", to, email.Data{URL: signupURL, L: loc, Brand: client.Branding})
+	if err != nil {
+		return err
+	}
+
+	return n.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		if err := tx.SignupTokens.Create(ctx, tokenData); err != nil {
+			return err
+		}
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
+}
+
+// ServeSignup follows the link from a signup notice and asks for the
+// password of the new account.
+func (h *AuthHandlers) ServeSignup(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	client := h.clientRegistry.Get(r.URL.Query().Get("client_id"))
+	loc := localizer(h.locales, r, "")
+
+	signupToken, err := h.findSignupToken(context.Background(), token)
+	if errors.Is(err, repo.ErrNotFound) {
+		h.renderAuthError(w, r, loc, client, "auth.signup_link_invalid")
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to load signup token", err))
+		return
+	}
+
+	h.renderSignupStep(w, r, loc, client, signupToken.Email, token, flowQuery(r), "")
+}
+
+// HandleSignup creates the account. Following the link proved the address,
+// so the user is logged in right away.
+func (h *AuthHandlers) HandleSignup(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		web.Error(w, r, "Invalid form", http.StatusBadRequest)
+		return
+	}
+
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+	clientID := r.URL.Query().Get("client_id")
+	client := h.clientRegistry.Get(clientID)
+	loc := localizer(h.locales, r, "")
+
+	ctx := context.Background()
+	signupToken, err := h.findSignupToken(ctx, token)
+	if errors.Is(err, repo.ErrNotFound) {
+		h.renderAuthError(w, r, loc, client, "auth.signup_link_invalid")
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to load signup token", err))
+		return
+	}
+
+	if err := h.pwdPolicies.Check(clientID, password, signupToken.Email); err != nil {
+		var policyErr *auth.PolicyError
+		if !errors.As(err, &policyErr) {
+			web.RenderError(w, r, apperror.Internal("Failed to check password", err))
+			return
+		}
+		h.renderSignupStep(w, r, loc, client, signupToken.Email, token, flowQuery(r), policyErr.Key, policyErr.Args...)
+		return
+	}
+
+	pwdHash, err := h.pwdHasher.Hash(password)
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to hash password", err))
+		return
+	}
+
+	var user *repo.User
+	err = h.txManager.WithTx(ctx, func(tx *repo.Tx) error {
+		var err error
+		if user, err = tx.Users.Create(ctx, signupToken.Email, pwdHash, loc.Locale()); err != nil {
+			return err
+		}
+		return tx.SignupTokens.MarkUsed(ctx, signupToken.ID)
+	})
+	if errors.Is(err, repo.ErrConflict) {
+		h.renderAuthError(w, r, loc, client, "auth.create_failed")
+		return
+	}
+	if err != nil {
+		web.RenderError(w, r, apperror.Internal("Failed to create account", err))
+		return
+	}
+
+	if !h.startSession(w, r, ctx, user.ID, client.ID) {
+		return
+	}
+
+	h.tmpls.For(client.ID).Render(w, r, "success.html", newPage(r, loc, client))
+}
+
+// findSignupToken returns the usable signup token, or an error wrapping
+// repo.ErrNotFound if it is unknown, used or expired.
+func (h *AuthHandlers) findSignupToken(ctx context.Context, token string) (*repo.SignupToken, error) {
+	signupToken, err := h.signupTokenRepo.FindByTokenHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if signupToken.UsedAt.Valid || time.Now().After(signupToken.ExpiresAt) {
+		return nil, fmt.Errorf("signup token used or expired: %w", repo.ErrNotFound)
+	}
+	return signupToken, nil
+}
+
+func (h *AuthHandlers) renderSignupStep(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, client *clients.Client, email, token, query, errKey string, errArgs ...any) {
+	if formError(w, r, loc, errKey, errArgs...) {
+		return
+	}
+	if errKey != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	data := AuthPageData{
+		Page:          newPage(r, loc, client),
+		Step:          "signup_password",
+		Email:         email,
+		Error:         loc.T(errKey, errArgs...),
+		Token:         token,
+		PostSignupURL: "/auth/signup?" + query,
+	}
+	h.tmpls.For(client.ID).Render(w, r, "auth.html", data)
+}
@@ -0,0 +1,191 @@
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Bundle holds one message catalog per supported locale, loaded from
+// i18n/locales/<locale>.json. Catalogs are flat key to message maps;
+// messages may contain fmt verbs filled in by Localizer.T.
+type Bundle struct {
+	fallback string
+	locales  []string
+	catalogs map[string]map[string]string
+	matcher  language.Matcher
+}
+
+// Option is a locale offered in a language picker, named in its own
+// language.
+type Option struct {
+	Locale string `json:"locale"`
+	Name   string `json:"name"`
+}
+
+func Load(fallback string) *Bundle {
+	b, err := load("i18n/locales", fallback)
+	if err != nil {
+		log.Fatalf("Failed to load message catalogs: %v", err)
+	}
+	return b
+}
+
+func load(dir, fallback string) (*Bundle, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	catalogs := make(map[string]map[string]string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+
+		locale := strings.TrimSuffix(filepath.Base(file), ".json")
+		if _, err := language.Parse(locale); err != nil {
+			return nil, fmt.Errorf("invalid locale file name %s: %w", file, err)
+		}
+		catalogs[locale] = messages
+	}
+
+	if _, ok := catalogs[fallback]; !ok {
+		return nil, fmt.Errorf("no catalog for default locale %q", fallback)
+	}
+
+	// The matcher falls back to its first tag, so the default locale
+	// goes first.
+	locales := []string{fallback}
+	for locale := range catalogs {
+		if locale != fallback {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales[1:])
+
+	tags := make([]language.Tag, len(locales))
+	for i, locale := range locales {
+		tags[i] = language.Make(locale)
+	}
+
+	return &Bundle{
+		fallback: fallback,
+		locales:  locales,
+		catalogs: catalogs,
+		matcher:  language.NewMatcher(tags),
+	}, nil
+}
+
+// Negotiate picks the locale for a request. An explicit ui_locales
+// parameter (space separated, RFC 5646) wins over the user's saved
+// preference, which wins over the Accept-Language header.
+func (b *Bundle) Negotiate(uiLocales, preferred, acceptLanguage string) *Localizer {
+	if locale, ok := b.match(parseList(uiLocales)); ok {
+		return b.Localizer(locale)
+	}
+	if locale, ok := b.match(parseList(preferred)); ok {
+		return b.Localizer(locale)
+	}
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	if locale, ok := b.match(tags); ok {
+		return b.Localizer(locale)
+	}
+	return b.Localizer(b.fallback)
+}
+
+// Supported returns the canonical supported locale for a user supplied
+// value, and false if none matches.
+func (b *Bundle) Supported(locale string) (string, bool) {
+	return b.match(parseList(locale))
+}
+
+// Localizer returns the localizer for a supported locale, or the default
+// one.
+func (b *Bundle) Localizer(locale string) *Localizer {
+	messages, ok := b.catalogs[locale]
+	if !ok {
+		locale = b.fallback
+		messages = b.catalogs[locale]
+	}
+	return &Localizer{
+		locale:   locale,
+		messages: messages,
+		fallback: b.catalogs[b.fallback],
+	}
+}
+
+func (b *Bundle) Options() []Option {
+	options := make([]Option, len(b.locales))
+	for i, locale := range b.locales {
+		options[i] = Option{Locale: locale, Name: b.Localizer(locale).T("language.name")}
+	}
+	return options
+}
+
+func (b *Bundle) match(tags []language.Tag) (string, bool) {
+	if len(tags) == 0 {
+		return "", false
+	}
+	_, index, confidence := b.matcher.Match(tags...)
+	if confidence == language.No {
+		return "", false
+	}
+	return b.locales[index], true
+}
+
+func parseList(s string) []language.Tag {
+	var tags []language.Tag
+	for _, field := range strings.Fields(s) {
+		if tag, err := language.Parse(field); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Localizer translates message keys for one locale. Keys missing from the
+// locale's catalog fall back to the default locale, then to the key
+// itself. A nil Localizer returns keys unchanged.
+type Localizer struct {
+	locale   string
+	messages map[string]string
+	fallback map[string]string
+}
+
+func (l *Localizer) Locale() string {
+	if l == nil {
+		return ""
+	}
+	return l.locale
+}
+
+func (l *Localizer) T(key string, args ...any) string {
+	if l == nil {
+		return key
+	}
+
+	msg, ok := l.messages[key]
+	if !ok {
+		if msg, ok = l.fallback[key]; !ok {
+			msg = key
+		}
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
@@ -2,36 +2,96 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/auth"
 	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey  contextKey = "userID"
+	SessionKey contextKey = "session"
+)
 
-func Auth(userRepo repo.UserRepo) func(http.Handler) http.Handler {
+func Auth(sessionRepo repo.SessionRepo) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			sessionCookie, err := r.Cookie("session")
 			if err != nil {
-				http.Redirect(w, r, "/", http.StatusSeeOther)
+				Unauthorized(w, r)
 				return
 			}
 
-			userID, ok := validateSession(sessionCookie.Value)
-			if !ok {
-				http.Redirect(w, r, "/", http.StatusSeeOther)
+			session, err := validateSession(r.Context(), sessionRepo, sessionCookie.Value)
+			if err != nil && !errors.Is(err, repo.ErrNotFound) {
+				web.RenderError(w, r, apperror.Internal("Failed to load session", err))
+				return
+			}
+			if err != nil {
+				Unauthorized(w, r)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx := context.WithValue(r.Context(), UserIDKey, session.UserID)
+			ctx = context.WithValue(ctx, SessionKey, session)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func validateSession(_ string) (int, bool) {
-	return 0, false
+// SessionClient returns the client the live session of r was started for,
+// or "" if there is none.
+func SessionClient(sessionRepo repo.SessionRepo, r *http.Request) string {
+	sessionCookie, err := r.Cookie("session")
+	if err != nil {
+		return ""
+	}
+
+	session, err := validateSession(r.Context(), sessionRepo, sessionCookie.Value)
+	if err != nil {
+		if !errors.Is(err, repo.ErrNotFound) {
+			log.Printf("Failed to load session: %v", err)
+		}
+		return ""
+	}
+	return session.ClientID
+}
+
+// validateSession returns the live session for token, or an error wrapping
+// repo.ErrNotFound if there is none.
+func validateSession(ctx context.Context, sessionRepo repo.SessionRepo, token string) (*repo.Session, error) {
+	if token == "" {
+		return nil, repo.ErrNotFound
+	}
+
+	session, err := sessionRepo.FindByTokenHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("session expired: %w", repo.ErrNotFound)
+	}
+
+	return session, nil
+}
+
+// Unauthorized clears the session cookie, then sends browsers to the login
+// page and tells JSON clients to log in. Handlers use it when the session
+// outlived its account.
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	if web.WantsJSON(r) {
+		web.Error(w, r, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
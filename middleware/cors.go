@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yookibooki/auth/web"
+)
+
+// CORSPolicy reports whether r, sent by a page on origin, may be made
+// cross-origin.
+type CORSPolicy func(r *http.Request, origin string) bool
+
+const corsMaxAge = 10 * time.Minute
+
+// CORS lets pages on the origins allowed by policy call the wrapped routes
+// with credentials, i.e. the session cookie. It answers preflight requests
+// itself, so it must run before Auth. Requests from other origins are
+// passed on without CORS headers, which keeps browsers from reading the
+// response; CSRF keeps them from changing anything.
+func CORS(policy CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if origin == "" || !policy(r, origin) {
+				if preflight {
+					web.Error(w, r, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+				h.Set("Access-Control-Allow-Headers", "Accept, Accept-Language, Content-Type, "+CSRFHeader)
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			h.Set("Access-Control-Expose-Headers", "Retry-After")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
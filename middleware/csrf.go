@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/yookibooki/auth/web"
+)
+
+const (
+	CSRFTokenKey contextKey = "csrfToken"
+
+	// CSRFField is the form field, and CSRFHeader the header for scripts,
+	// carrying the token of a state-changing request.
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+
+	csrfCookie = "csrf"
+)
+
+// CSRF protects every POST, PUT, PATCH and DELETE outside the exempt path
+// prefixes. Requests must come from the same origin, judged by
+// Sec-Fetch-Site or Origin, and carry the token from the request context
+// (CSRFTokenKey), which pages put into their forms.
+//
+// Tokens are signed double-submit cookies: the browser keeps a random value
+// in a cookie, and the token is its HMAC together with the session cookie.
+// A cookie planted by a sibling domain is therefore useless without key,
+// and a token stops working once the session changes.
+//
+// Pages on other origins cannot read the token. Their requests to the
+// CORS-enabled routes under corsPath are accepted when policy allows the
+// Origin header, which browsers do not let pages forge; anywhere else they
+// are rejected. Neither do JSON bodies need the token: a cross-origin page
+// can only send them after a CORS preflight, and Sec-Fetch-Site or Origin
+// still has to pass.
+func CSRF(key []byte, secure bool, policy CORSPolicy, corsPath string, exempt ...string) func(http.Handler) http.Handler {
+	crossOrigin := http.NewCrossOriginProtection()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := ""
+			if cookie, err := r.Cookie(csrfCookie); err == nil {
+				nonce = cookie.Value
+			}
+			if nonce == "" {
+				random := make([]byte, 32)
+				if _, err := rand.Read(random); err != nil {
+					log.Printf("Failed to generate CSRF cookie: %v", err)
+					web.Error(w, r, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				nonce = base64.RawURLEncoding.EncodeToString(random)
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrfCookie,
+					Value:    nonce,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   secure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			session := ""
+			if cookie, err := r.Cookie("session"); err == nil {
+				session = cookie.Value
+			}
+			token := signCSRF(key, nonce, session)
+
+			if !isSafeMethod(r.Method) && !isExempt(r.URL.Path, exempt) {
+				trusted := web.SendsJSON(r)
+				if err := crossOrigin.Check(r); err != nil {
+					origin := r.Header.Get("Origin")
+					if origin == "" || !isUnder(r.URL.Path, corsPath) || !policy(r, origin) {
+						web.Error(w, r, "Cross-origin request rejected", http.StatusForbidden)
+						return
+					}
+					trusted = true
+				}
+
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFField)
+				}
+				if !trusted && !hmac.Equal([]byte(sent), []byte(token)) {
+					web.Error(w, r, "Invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), CSRFTokenKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func signCSRF(key []byte, nonce, session string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce + "|" + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isUnder reports whether path is dir itself or lies below it.
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+func isExempt(path string, exempt []string) bool {
+	for _, prefix := range exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
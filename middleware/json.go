@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/yookibooki/auth/web"
+)
+
+const maxJSONBody = 1 << 20
+
+// JSONBody lets handlers read the fields of a JSON object body with
+// r.FormValue, as if it had been posted as a form, so that every flow
+// accepts both. Numbers and booleans are passed in their JSON form. Bodies
+// under the exempt path prefixes are left to their handlers.
+func JSONBody(exempt ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !web.SendsJSON(r) || isExempt(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			form, err := decodeJSONForm(http.MaxBytesReader(w, r.Body, maxJSONBody))
+			if err != nil {
+				web.Error(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			r.PostForm = form
+			r.Form = r.URL.Query()
+			for name, values := range form {
+				r.Form[name] = append(values, r.Form[name]...)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func decodeJSONForm(body io.Reader) (url.Values, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		return nil, errors.New("Request body must be a JSON object")
+	}
+
+	form := url.Values{}
+	for name, raw := range fields {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, errors.New("Request body must be a JSON object")
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			form.Set(name, v)
+		case float64, bool:
+			form.Set(name, string(raw))
+		default:
+			return nil, fmt.Errorf("Field %q must be a string, number or boolean", name)
+		}
+	}
+	return form, nil
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yookibooki/auth/ratelimit"
+	"github.com/yookibooki/auth/web"
+)
+
+// RateLimitKey picks what a request is counted against. Requests with an
+// empty key are not limited by the rule.
+type RateLimitKey func(r *http.Request) string
+
+type RateLimitRule struct {
+	Limiter *ratelimit.Limiter
+	Key     RateLimitKey
+}
+
+// RateLimit rejects requests exceeding any of the rules with 429 and a
+// Retry-After header, and calls onLimited (if not nil) for each of them.
+// Rules are checked in order up to the first that rejects the request; the
+// earlier ones get their request back, so a rejected request costs no
+// budget. The limiter fails open: a store error is logged and the request
+// let through.
+func RateLimit(onLimited func(r *http.Request), rules ...RateLimitRule) func(http.Handler) http.Handler {
+	type taken struct {
+		limiter *ratelimit.Limiter
+		key     string
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var retryAfter time.Duration
+			var allowed []taken
+			limited := false
+			for _, rule := range rules {
+				key := rule.Key(r)
+				if key == "" {
+					continue
+				}
+
+				result, err := rule.Limiter.Allow(r.Context(), key)
+				if err != nil {
+					log.Printf("Rate limiter failed: %v", err)
+					continue
+				}
+				if !result.Allowed {
+					limited = true
+					retryAfter = result.RetryAfter
+					break
+				}
+				allowed = append(allowed, taken{rule.Limiter, key})
+			}
+
+			if limited {
+				for _, t := range allowed {
+					if err := t.limiter.Refund(r.Context(), t.key); err != nil {
+						log.Printf("Rate limiter failed: %v", err)
+					}
+				}
+				if onLimited != nil {
+					onLimited(r)
+				}
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+				web.Error(w, r, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// KeyByIP counts requests per client address. Behind a reverse proxy set
+// trustProxy to use the address the proxy appended to X-Forwarded-For.
+func KeyByIP(trustProxy bool) RateLimitKey {
+	return func(r *http.Request) string {
+		if trustProxy {
+			if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+				hops := strings.Split(forwarded[len(forwarded)-1], ",")
+				if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+					return ip
+				}
+			}
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// KeyByUser counts requests per signed-in user. It must run after Auth.
+func KeyByUser(r *http.Request) string {
+	if userID, ok := r.Context().Value(UserIDKey).(int); ok {
+		return strconv.Itoa(userID)
+	}
+	return ""
+}
+
+// KeyByFormValue counts requests per value of a query or form field, such
+// as email or client_id. Values are compared case-insensitively.
+func KeyByFormValue(name string) RateLimitKey {
+	return func(r *http.Request) string {
+		return strings.ToLower(strings.TrimSpace(r.FormValue(name)))
+	}
+}
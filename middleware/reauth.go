@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/yookibooki/auth/apperror"
+	"github.com/yookibooki/auth/repo"
+	"github.com/yookibooki/auth/web"
+)
+
+const ReauthPath = "/account/reauth"
+
+// RequireRecentAuth guards sensitive routes. It must run after Auth and sends
+// the user to ReauthPath when the session's last password (or MFA)
+// verification is older than maxAge, or answers JSON requests with a 403
+// "reauth_required" error. Form posts cannot be replayed, so only
+// GET requests carry a next location.
+func RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, ok := r.Context().Value(SessionKey).(*repo.Session)
+			if !ok {
+				Unauthorized(w, r)
+				return
+			}
+
+			if time.Since(session.ReauthAt) > maxAge {
+				if web.WantsJSON(r) {
+					web.RenderError(w, r, &apperror.Error{
+						Status:  http.StatusForbidden,
+						Code:    "reauth_required",
+						Message: "Confirm your password at " + ReauthPath,
+					})
+					return
+				}
+				target := ReauthPath
+				if r.Method == http.MethodGet {
+					target += "?next=" + url.QueryEscape(r.URL.Path)
+				}
+				http.Redirect(w, r, target, http.StatusSeeOther)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"log"
 	"net/http"
+
+	"github.com/yookibooki/auth/web"
 )
 
 func Recovery(next http.Handler) http.Handler {
@@ -10,7 +12,7 @@ func Recovery(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				log.Printf("PANIC: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				web.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
 
@@ -0,0 +1,167 @@
+package pow
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yookibooki/auth/ratelimit"
+)
+
+var (
+	ErrInvalid = errors.New("invalid proof of work")
+	ErrExpired = errors.New("proof of work challenge expired")
+	ErrSpent   = errors.New("proof of work challenge already used")
+)
+
+// Challenge is handed to the page. A zero Challenge means no proof of work
+// is required.
+type Challenge struct {
+	Token      string `json:"token"`
+	Difficulty int    `json:"difficulty"`
+}
+
+type Options struct {
+	Difficulty    int           // leading zero bits normally required
+	MaxDifficulty int           // upper bound while escalating
+	TTL           time.Duration // how long a challenge can be solved and used
+	// Every doubling of rate limit rejections within AbuseWindow beyond
+	// AbuseThreshold adds one bit of difficulty.
+	AbuseWindow    time.Duration
+	AbuseThreshold int
+}
+
+// Puzzle issues hashcash-style challenges: the browser searches for a nonce
+// such that SHA-256(token ":" nonce) starts with Difficulty zero bits and
+// posts both back. Tokens are signed, so nothing is stored until spent.
+type Puzzle struct {
+	key   []byte
+	opts  Options
+	store ratelimit.Store
+	abuse *ratelimit.Counter
+}
+
+// New returns a Puzzle signing challenges with key. The store remembers
+// spent challenges and recent abuse, so instances sharing a store also
+// share both.
+func New(key []byte, store ratelimit.Store, opts Options) *Puzzle {
+	return &Puzzle{
+		key:   key,
+		opts:  opts,
+		store: store,
+		abuse: ratelimit.NewCounter("pow-abuse", store, opts.AbuseWindow),
+	}
+}
+
+// Issue creates a challenge at the current difficulty.
+func (p *Puzzle) Issue(ctx context.Context) (Challenge, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return Challenge{}, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	difficulty := p.Difficulty(ctx)
+	expires := time.Now().Add(p.opts.TTL).Unix()
+	payload := fmt.Sprintf("%d.%d.%s", expires, difficulty, hex.EncodeToString(random))
+
+	return Challenge{Token: payload + "." + p.sign(payload), Difficulty: difficulty}, nil
+}
+
+// Verify checks that nonce solves the challenge token and spends the
+// challenge so it cannot be used again.
+func (p *Puzzle) Verify(ctx context.Context, token, nonce string) error {
+	payload, mac, ok := cutLast(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(p.sign(payload))) {
+		return ErrInvalid
+	}
+
+	fields := strings.Split(payload, ".")
+	if len(fields) != 3 {
+		return ErrInvalid
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	difficulty, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return ErrInvalid
+	}
+
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	if leadingZeros(sum[:]) < difficulty {
+		return ErrInvalid
+	}
+
+	spent := false
+	err = p.store.Update(ctx, "pow-spent:"+mac, p.opts.TTL, func(s ratelimit.State) ratelimit.State {
+		spent = s.Value > 0
+		s.Value = 1
+		return s
+	})
+	if err != nil {
+		return err
+	}
+	if spent {
+		return ErrSpent
+	}
+	return nil
+}
+
+// ReportAbuse is called whenever the rate limiter rejects a request and
+// raises the difficulty of new challenges while rejections keep coming.
+func (p *Puzzle) ReportAbuse(ctx context.Context) error {
+	return p.abuse.Add(ctx, "")
+}
+
+// Difficulty is the number of leading zero bits new challenges require.
+// Counter errors leave it at the base difficulty.
+func (p *Puzzle) Difficulty(ctx context.Context) int {
+	difficulty := p.opts.Difficulty
+
+	rejections, err := p.abuse.Count(ctx, "")
+	if err == nil && p.opts.AbuseThreshold > 0 && rejections >= float64(p.opts.AbuseThreshold) {
+		difficulty += 1 + int(math.Log2(rejections/float64(p.opts.AbuseThreshold)))
+	}
+
+	return min(difficulty, max(p.opts.MaxDifficulty, p.opts.Difficulty))
+}
+
+func (p *Puzzle) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func leadingZeros(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Counter estimates how many events happened during the last window, for
+// reacting to load rather than rejecting requests.
+type Counter struct {
+	name   string
+	store  Store
+	window time.Duration
+}
+
+func NewCounter(name string, store Store, window time.Duration) *Counter {
+	return &Counter{name: name, store: store, window: window}
+}
+
+// Add records an event for key.
+func (c *Counter) Add(ctx context.Context, key string) error {
+	return c.store.Update(ctx, c.name+":"+key, 2*c.window, func(s State) State {
+		s, _ = slide(s, time.Now().UTC(), c.window)
+		s.Value++
+		return s
+	})
+}
+
+// Count returns the number of events for key during the last window.
+func (c *Counter) Count(ctx context.Context, key string) (float64, error) {
+	var count float64
+	err := c.store.Update(ctx, c.name+":"+key, 2*c.window, func(s State) State {
+		s, elapsed := slide(s, time.Now().UTC(), c.window)
+		count = s.Prev*(1-elapsed) + s.Value
+		return s
+	})
+	return count, err
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the state in process. Every instance counts on its
+// own, so use a shared store when running more than one.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (m *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) error {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	entry, ok := m.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = memoryEntry{}
+	}
+
+	m.entries[key] = memoryEntry{state: fn(entry.state), expires: now.Add(ttl)}
+	return nil
+}
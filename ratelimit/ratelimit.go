@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	// TokenBucket allows bursts of up to Requests and refills at
+	// Requests per Window.
+	TokenBucket Algorithm = "token-bucket"
+	// SlidingWindow allows Requests in any Window, estimated from the
+	// counts of the current and the previous fixed window.
+	SlidingWindow Algorithm = "sliding-window"
+)
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case TokenBucket, SlidingWindow:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown rate limit algorithm %q", s)
+}
+
+// Limit is a budget of Requests per Window. A Limit with no Requests is
+// unlimited.
+type Limit struct {
+	Algorithm Algorithm
+	Requests  int
+	Window    time.Duration
+}
+
+// Result is the outcome of one request against a Limit.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration // zero when allowed
+}
+
+// State is what a Store keeps per key. The zero State is a key that has
+// not been seen (or has expired).
+type State struct {
+	Value float64   // tokens left, or requests in the current window
+	Prev  float64   // requests in the previous window
+	At    time.Time // last refill, or start of the current window
+}
+
+// Store holds the State of every key. Implementations must run fn
+// atomically per key, also across instances sharing the store.
+type Store interface {
+	// Update replaces the state of key with the one returned by fn. The
+	// state may be forgotten ttl after the update.
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) error
+}
+
+// Limiter applies one Limit to the keys of a named scope, e.g. the email
+// addresses posted to a single endpoint.
+type Limiter struct {
+	name  string
+	store Store
+	limit Limit
+}
+
+func NewLimiter(name string, store Store, limit Limit) *Limiter {
+	return &Limiter{name: name, store: store, limit: limit}
+}
+
+// Allow takes one request for key from its budget.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	if l.limit.Requests <= 0 || l.limit.Window <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	now := time.Now().UTC()
+	var result Result
+	err := l.store.Update(ctx, l.name+":"+key, l.ttl(), func(s State) State {
+		s, result = l.take(s, now)
+		return s
+	})
+	return result, err
+}
+
+// Refund gives back a request Allow took for key, for when a later check
+// rejects the request after all.
+func (l *Limiter) Refund(ctx context.Context, key string) error {
+	if l.limit.Requests <= 0 || l.limit.Window <= 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	return l.store.Update(ctx, l.name+":"+key, l.ttl(), func(s State) State {
+		if l.limit.Algorithm == SlidingWindow {
+			s, _ = slide(s, now, l.limit.Window)
+			s.Value = math.Max(0, s.Value-1)
+			return s
+		}
+		if !s.At.IsZero() {
+			s.Value = math.Min(float64(l.limit.Requests), s.Value+1)
+		}
+		return s
+	})
+}
+
+// ttl is how long it takes a key to return to its full budget.
+func (l *Limiter) ttl() time.Duration {
+	if l.limit.Algorithm == SlidingWindow {
+		return 2 * l.limit.Window
+	}
+	return l.limit.Window
+}
+
+func (l *Limiter) take(s State, now time.Time) (State, Result) {
+	if l.limit.Algorithm == SlidingWindow {
+		return l.takeWindow(s, now)
+	}
+	return l.takeToken(s, now)
+}
+
+func (l *Limiter) takeToken(s State, now time.Time) (State, Result) {
+	capacity := float64(l.limit.Requests)
+	perSecond := capacity / l.limit.Window.Seconds()
+
+	tokens := capacity
+	if !s.At.IsZero() {
+		tokens = math.Min(capacity, s.Value+now.Sub(s.At).Seconds()*perSecond)
+	}
+
+	if tokens < 1 {
+		wait := time.Duration((1 - tokens) / perSecond * float64(time.Second))
+		return State{Value: tokens, At: now}, Result{RetryAfter: wait}
+	}
+
+	tokens--
+	return State{Value: tokens, At: now}, Result{Allowed: true, Remaining: int(tokens)}
+}
+
+func (l *Limiter) takeWindow(s State, now time.Time) (State, Result) {
+	s, elapsed := slide(s, now, l.limit.Window)
+	limit := float64(l.limit.Requests)
+	used := s.Prev*(1-elapsed) + s.Value
+
+	if used+1 > limit {
+		return s, Result{RetryAfter: l.windowWait(s, elapsed)}
+	}
+
+	s.Value++
+	return s, Result{Allowed: true, Remaining: int(limit - used - 1)}
+}
+
+// windowWait is how long until the sliding estimate leaves room for one
+// more request.
+func (l *Limiter) windowWait(s State, elapsed float64) time.Duration {
+	limit := float64(l.limit.Requests)
+	window := float64(l.limit.Window)
+
+	if s.Value+1 <= limit {
+		// The previous window's share decays in time.
+		at := 1 - (limit-1-s.Value)/s.Prev
+		return time.Duration((at - elapsed) * window)
+	}
+
+	// The current window is full on its own, wait until it becomes the
+	// previous one and has decayed enough.
+	at := 1 - (limit-1)/s.Value
+	return time.Duration((1 - elapsed + at) * window)
+}
+
+// slide moves a sliding window state to the fixed window containing now
+// and returns how far into it now is, as a fraction.
+func slide(s State, now time.Time, window time.Duration) (State, float64) {
+	start := now.Truncate(window)
+
+	switch {
+	case s.At.Equal(start):
+	case s.At.Equal(start.Add(-window)):
+		s = State{Prev: s.Value}
+	default:
+		s = State{}
+	}
+	s.At = start
+
+	return s, float64(now.Sub(start)) / float64(window)
+}
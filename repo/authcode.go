@@ -27,10 +27,10 @@ type AuthCodeRepo interface {
 }
 
 type authCodeRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
-func NewAuthCodeRepo(db *sql.DB) AuthCodeRepo {
+func NewAuthCodeRepo(db DBTX) AuthCodeRepo {
 	return &authCodeRepo{db: db}
 }
 
@@ -49,7 +49,7 @@ func (r *authCodeRepo) Create(ctx context.Context, code *auth.AuthCode) error {
 		code.State,
 		code.ExpiresAt,
 	).Scan(&id)
-	return err
+	return dbError(err)
 }
 
 func (r *authCodeRepo) FindByCodeHash(ctx context.Context, codeHash string) (*AuthCode, error) {
@@ -70,19 +70,31 @@ func (r *authCodeRepo) FindByCodeHash(ctx context.Context, codeHash string) (*Au
 		&code.UsedAt,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	return &code, nil
 }
 
+// MarkUsed consumes the code, or returns ErrNotFound if it was already
+// used, so of two concurrent requests only one succeeds.
 func (r *authCodeRepo) MarkUsed(ctx context.Context, id int) error {
 	query := `
 		UPDATE auth_codes
 		SET used_at = NOW()
-		WHERE id = $1
+		WHERE id = $1 AND used_at IS NULL
 	`
-	_, err := r.db.ExecContext(ctx, query, id)
-	return err
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return dbError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (r *authCodeRepo) CleanupExpired(ctx context.Context) error {
@@ -91,5 +103,5 @@ func (r *authCodeRepo) CleanupExpired(ctx context.Context) error {
 		WHERE expires_at < NOW()
 	`
 	_, err := r.db.ExecContext(ctx, query)
-	return err
+	return dbError(err)
 }
@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/yookibooki/auth/auth"
+)
+
+type EmailChangeToken struct {
+	ID        int
+	TokenHash string
+	UserID    int
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type EmailChangeTokenRepo interface {
+	Create(ctx context.Context, token *auth.EmailChangeToken) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*EmailChangeToken, error)
+	MarkUsed(ctx context.Context, id int) error
+	CleanupExpired(ctx context.Context) error
+}
+
+type emailChangeTokenRepo struct {
+	db DBTX
+}
+
+func NewEmailChangeTokenRepo(db DBTX) EmailChangeTokenRepo {
+	return &emailChangeTokenRepo{db: db}
+}
+
+func (r *emailChangeTokenRepo) Create(ctx context.Context, token *auth.EmailChangeToken) error {
+	query := `
+		INSERT INTO email_change_tokens (token_hash, user_id, email, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.ExecContext(ctx, query, token.TokenHash, token.UserID, token.Email, token.ExpiresAt)
+	return dbError(err)
+}
+
+func (r *emailChangeTokenRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*EmailChangeToken, error) {
+	query := `
+		SELECT id, token_hash, user_id, email, expires_at, used_at
+		FROM email_change_tokens
+		WHERE token_hash = $1
+	`
+	var token EmailChangeToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.UserID,
+		&token.Email,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &token, nil
+}
+
+// MarkUsed consumes the token, or returns ErrNotFound if it was already
+// used, so of two concurrent requests only one succeeds.
+func (r *emailChangeTokenRepo) MarkUsed(ctx context.Context, id int) error {
+	query := `
+		UPDATE email_change_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return dbError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *emailChangeTokenRepo) CleanupExpired(ctx context.Context) error {
+	query := `
+		DELETE FROM email_change_tokens
+		WHERE expires_at < NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query)
+	return dbError(err)
+}
@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is returned by lookups that match no row.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by writes that would violate a unique
+	// constraint, e.g. a second account with the same email.
+	ErrConflict = errors.New("conflict")
+)
+
+// uniqueViolation is the Postgres error code of a unique constraint
+// violation.
+const uniqueViolation = "23505"
+
+// dbError translates driver errors into ErrNotFound and ErrConflict,
+// keeping the original error in the chain.
+func dbError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return fmt.Errorf("%w: %w", ErrConflict, err)
+	}
+	return err
+}
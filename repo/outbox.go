@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/yookibooki/auth/email"
+)
+
+type OutboxRepo interface {
+	email.Outbox
+	Enqueue(ctx context.Context, msg *email.Message) error
+}
+
+type outboxRepo struct {
+	db DBTX
+}
+
+func NewOutboxRepo(db DBTX) OutboxRepo {
+	return &outboxRepo{db: db}
+}
+
+func (r *outboxRepo) Enqueue(ctx context.Context, msg *email.Message) error {
+	query := `
+		INSERT INTO email_outbox (recipient, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.ExecContext(ctx, query, msg.To, msg.Subject, msg.Text, msg.HTML)
+	return dbError(err)
+}
+
+func (r *outboxRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]email.OutboxEntry, error) {
+	query := `
+		UPDATE email_outbox
+		SET locked_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = 'pending'
+			  AND next_attempt_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, text_body, html_body, attempts
+	`
+	rows, err := r.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var entries []email.OutboxEntry
+	for rows.Next() {
+		var entry email.OutboxEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Message.To,
+			&entry.Message.Subject,
+			&entry.Message.Text,
+			&entry.Message.HTML,
+			&entry.Attempts,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (r *outboxRepo) MarkSent(ctx context.Context, id int) error {
+	query := `DELETE FROM email_outbox WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return dbError(err)
+}
+
+func (r *outboxRepo) MarkFailed(ctx context.Context, id int, errMsg string, nextAttemptAt time.Time, dead bool) error {
+	query := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1,
+		    last_error = $2,
+		    next_attempt_at = $3,
+		    locked_until = NULL,
+		    status = CASE WHEN $4::BOOLEAN THEN 'dead' ELSE status END
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id, errMsg, nextAttemptAt, dead)
+	return dbError(err)
+}
+
+func (r *outboxRepo) Depth(ctx context.Context) (email.OutboxDepth, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'pending'),
+			COUNT(*) FILTER (WHERE status = 'dead')
+		FROM email_outbox
+	`
+	var depth email.OutboxDepth
+	err := r.db.QueryRowContext(ctx, query).Scan(&depth.Pending, &depth.Dead)
+	return depth, dbError(err)
+}
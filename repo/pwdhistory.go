@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"context"
+)
+
+type PwdHistoryRepo interface {
+	Add(ctx context.Context, userID int, pwdHash string) error
+	ListRecent(ctx context.Context, userID, limit int) ([]string, error)
+	Prune(ctx context.Context, userID, keep int) error
+}
+
+type pwdHistoryRepo struct {
+	db DBTX
+}
+
+func NewPwdHistoryRepo(db DBTX) PwdHistoryRepo {
+	return &pwdHistoryRepo{db: db}
+}
+
+func (r *pwdHistoryRepo) Add(ctx context.Context, userID int, pwdHash string) error {
+	query := `
+		INSERT INTO pwd_history (user_id, pwd_hash)
+		VALUES ($1, $2)
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, pwdHash)
+	return dbError(err)
+}
+
+func (r *pwdHistoryRepo) ListRecent(ctx context.Context, userID, limit int) ([]string, error) {
+	query := `
+		SELECT pwd_hash
+		FROM pwd_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func (r *pwdHistoryRepo) Prune(ctx context.Context, userID, keep int) error {
+	query := `
+		DELETE FROM pwd_history
+		WHERE user_id = $1
+		  AND id NOT IN (
+			SELECT id
+			FROM pwd_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		  )
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, keep)
+	return dbError(err)
+}
@@ -24,10 +24,10 @@ type PwdResetTokenRepo interface {
 }
 
 type pwdResetTokenRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
-func NewPwdResetTokenRepo(db *sql.DB) PwdResetTokenRepo {
+func NewPwdResetTokenRepo(db DBTX) PwdResetTokenRepo {
 	return &pwdResetTokenRepo{db: db}
 }
 
@@ -43,7 +43,7 @@ func (r *pwdResetTokenRepo) Create(ctx context.Context, token *auth.PwdResetToke
 		token.UserID,
 		token.ExpiresAt,
 	).Scan(&id)
-	return err
+	return dbError(err)
 }
 
 func (r *pwdResetTokenRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*PwdResetToken, error) {
@@ -61,19 +61,31 @@ func (r *pwdResetTokenRepo) FindByTokenHash(ctx context.Context, tokenHash strin
 		&token.UsedAt,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	return &token, nil
 }
 
+// MarkUsed consumes the token, or returns ErrNotFound if it was already
+// used, so of two concurrent requests only one succeeds.
 func (r *pwdResetTokenRepo) MarkUsed(ctx context.Context, id int) error {
 	query := `
 		UPDATE pwd_reset_tokens
 		SET used_at = NOW()
-		WHERE id = $1
+		WHERE id = $1 AND used_at IS NULL
 	`
-	_, err := r.db.ExecContext(ctx, query, id)
-	return err
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return dbError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (r *pwdResetTokenRepo) CleanupExpired(ctx context.Context) error {
@@ -82,5 +94,5 @@ func (r *pwdResetTokenRepo) CleanupExpired(ctx context.Context) error {
 		WHERE expires_at < NOW()
 	`
 	_, err := r.db.ExecContext(ctx, query)
-	return err
+	return dbError(err)
 }
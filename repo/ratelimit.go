@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/yookibooki/auth/ratelimit"
+)
+
+// RateLimitRepo is a ratelimit.Store shared by every instance using the
+// database.
+type RateLimitRepo interface {
+	ratelimit.Store
+	CleanupExpired(ctx context.Context) error
+}
+
+type rateLimitRepo struct {
+	db *sql.DB
+}
+
+// NewRateLimitRepo needs the database itself because every update runs in
+// its own transaction.
+func NewRateLimitRepo(db *sql.DB) RateLimitRepo {
+	return &rateLimitRepo{db: db}
+}
+
+func (r *rateLimitRepo) Update(ctx context.Context, key string, ttl time.Duration, fn func(ratelimit.State) ratelimit.State) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Create the row first so that concurrent first hits serialize on its
+	// lock instead of both starting from an empty state.
+	insert := `
+		INSERT INTO rate_limits (key, expires_at)
+		VALUES ($1, '-infinity')
+		ON CONFLICT (key) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, insert, key); err != nil {
+		return dbError(err)
+	}
+
+	query := `
+		SELECT value, prev, stamp, expires_at < NOW()
+		FROM rate_limits
+		WHERE key = $1
+		FOR UPDATE
+	`
+	var state ratelimit.State
+	var stamp sql.NullTime
+	var expired bool
+	if err := tx.QueryRowContext(ctx, query, key).Scan(&state.Value, &state.Prev, &stamp, &expired); err != nil {
+		return dbError(err)
+	}
+	if expired {
+		state = ratelimit.State{}
+	} else if stamp.Valid {
+		state.At = stamp.Time.UTC()
+	}
+
+	state = fn(state)
+
+	update := `
+		UPDATE rate_limits
+		SET value = $2, prev = $3, stamp = $4, expires_at = $5
+		WHERE key = $1
+	`
+	if _, err := tx.ExecContext(ctx, update, key, state.Value, state.Prev, state.At, time.Now().Add(ttl)); err != nil {
+		return dbError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *rateLimitRepo) CleanupExpired(ctx context.Context) error {
+	query := `
+		DELETE FROM rate_limits
+		WHERE expires_at < NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query)
+	return dbError(err)
+}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/yookibooki/auth/auth"
+)
+
+type Session struct {
+	ID        int
+	TokenHash string
+	UserID    int
+	ClientID  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	ReauthAt  time.Time
+}
+
+type SessionRepo interface {
+	Create(ctx context.Context, session *auth.Session) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*Session, error)
+	MarkReauthenticated(ctx context.Context, id int) error
+	Delete(ctx context.Context, id int) error
+	DeleteByUserID(ctx context.Context, userID int) error
+	CleanupExpired(ctx context.Context) error
+}
+
+type sessionRepo struct {
+	db DBTX
+}
+
+func NewSessionRepo(db DBTX) SessionRepo {
+	return &sessionRepo{db: db}
+}
+
+func (r *sessionRepo) Create(ctx context.Context, session *auth.Session) error {
+	query := `
+		INSERT INTO sessions (token_hash, user_id, client_id, expires_at, reauth_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	var id int
+	err := r.db.QueryRowContext(ctx, query,
+		session.TokenHash,
+		session.UserID,
+		session.ClientID,
+		session.ExpiresAt,
+		session.ReauthAt,
+	).Scan(&id)
+	return dbError(err)
+}
+
+func (r *sessionRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*Session, error) {
+	query := `
+		SELECT id, token_hash, user_id, client_id, created_at, expires_at, reauth_at
+		FROM sessions
+		WHERE token_hash = $1
+	`
+	var session Session
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&session.ID,
+		&session.TokenHash,
+		&session.UserID,
+		&session.ClientID,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.ReauthAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &session, nil
+}
+
+func (r *sessionRepo) MarkReauthenticated(ctx context.Context, id int) error {
+	query := `
+		UPDATE sessions
+		SET reauth_at = NOW()
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return dbError(err)
+}
+
+func (r *sessionRepo) Delete(ctx context.Context, id int) error {
+	query := `DELETE FROM sessions WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return dbError(err)
+}
+
+func (r *sessionRepo) DeleteByUserID(ctx context.Context, userID int) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return dbError(err)
+}
+
+func (r *sessionRepo) CleanupExpired(ctx context.Context) error {
+	query := `
+		DELETE FROM sessions
+		WHERE expires_at < NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query)
+	return dbError(err)
+}
@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/yookibooki/auth/auth"
+)
+
+type SignupToken struct {
+	ID        int
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type SignupTokenRepo interface {
+	Create(ctx context.Context, token *auth.SignupToken) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*SignupToken, error)
+	MarkUsed(ctx context.Context, id int) error
+	CleanupExpired(ctx context.Context) error
+}
+
+type signupTokenRepo struct {
+	db DBTX
+}
+
+func NewSignupTokenRepo(db DBTX) SignupTokenRepo {
+	return &signupTokenRepo{db: db}
+}
+
+func (r *signupTokenRepo) Create(ctx context.Context, token *auth.SignupToken) error {
+	query := `
+		INSERT INTO signup_tokens (token_hash, email, expires_at)
+		VALUES ($1, $2, $3)
+	`
+	_, err := r.db.ExecContext(ctx, query, token.TokenHash, token.Email, token.ExpiresAt)
+	return dbError(err)
+}
+
+func (r *signupTokenRepo) FindByTokenHash(ctx context.Context, tokenHash string) (*SignupToken, error) {
+	query := `
+		SELECT id, token_hash, email, expires_at, used_at
+		FROM signup_tokens
+		WHERE token_hash = $1
+	`
+	var token SignupToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.TokenHash,
+		&token.Email,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &token, nil
+}
+
+func (r *signupTokenRepo) MarkUsed(ctx context.Context, id int) error {
+	query := `
+		UPDATE signup_tokens
+		SET used_at = NOW()
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return dbError(err)
+}
+
+func (r *signupTokenRepo) CleanupExpired(ctx context.Context) error {
+	query := `
+		DELETE FROM signup_tokens
+		WHERE expires_at < NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query)
+	return dbError(err)
+}
@@ -0,0 +1,39 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/yookibooki/auth/email"
+)
+
+type SuppressionRepo interface {
+	email.Suppressions
+}
+
+type suppressionRepo struct {
+	db DBTX
+}
+
+func NewSuppressionRepo(db DBTX) SuppressionRepo {
+	return &suppressionRepo{db: db}
+}
+
+func (r *suppressionRepo) IsSuppressed(ctx context.Context, address string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM email_suppressions WHERE email = LOWER($1))`
+	var suppressed bool
+	err := r.db.QueryRowContext(ctx, query, address).Scan(&suppressed)
+	return suppressed, dbError(err)
+}
+
+func (r *suppressionRepo) Suppress(ctx context.Context, address, reason, detail string) error {
+	query := `
+		INSERT INTO email_suppressions (email, reason, detail)
+		VALUES (LOWER($1), $2, $3)
+		ON CONFLICT (email) DO UPDATE
+		SET reason = EXCLUDED.reason,
+		    detail = EXCLUDED.detail,
+		    updated_at = NOW()
+	`
+	_, err := r.db.ExecContext(ctx, query, address, reason, detail)
+	return dbError(err)
+}
@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so every repo can run
+// inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tx exposes the repos bound to a single database transaction.
+type Tx struct {
+	Users          UserRepo
+	AuthCodes      AuthCodeRepo
+	PwdResetTokens PwdResetTokenRepo
+	SignupTokens   SignupTokenRepo
+	EmailChanges   EmailChangeTokenRepo
+	PwdHistory     PwdHistoryRepo
+	Outbox         OutboxRepo
+}
+
+type TxManager interface {
+	WithTx(ctx context.Context, fn func(tx *Tx) error) error
+}
+
+type txManager struct {
+	db *sql.DB
+}
+
+func NewTxManager(db *sql.DB) TxManager {
+	return &txManager{db: db}
+}
+
+// WithTx runs fn in a transaction, committing if it returns nil and rolling
+// back otherwise.
+func (m *txManager) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	sqlTx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	tx := &Tx{
+		Users:          NewUserRepo(sqlTx),
+		AuthCodes:      NewAuthCodeRepo(sqlTx),
+		PwdResetTokens: NewPwdResetTokenRepo(sqlTx),
+		SignupTokens:   NewSignupTokenRepo(sqlTx),
+		EmailChanges:   NewEmailChangeTokenRepo(sqlTx),
+		PwdHistory:     NewPwdHistoryRepo(sqlTx),
+		Outbox:         NewOutboxRepo(sqlTx),
+	}
+
+	if err := fn(tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
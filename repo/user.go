@@ -3,52 +3,75 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type User struct {
-	ID      int
-	Email   string
-	PwdHash string
+	ID                 int
+	Email              string
+	PwdHash            string
+	PwdChangedAt       time.Time
+	MustChangePassword bool
+	Locale             string // empty when the user never chose one
+	FailedLogins       int    // since the last successful login or password change
+	LastFailedLoginAt  sql.NullTime
+	LockedUntil        sql.NullTime
 }
 
 type UserRepo interface {
-	Create(ctx context.Context, email, pwdHash string) (*User, error)
+	Create(ctx context.Context, email, pwdHash, locale string) (*User, error)
 	FindByEmail(ctx context.Context, email string) (*User, error)
 	FindByID(ctx context.Context, id int) (*User, error)
+	FindByIDForUpdate(ctx context.Context, id int) (*User, error)
 	UpdateEmail(ctx context.Context, id int, email string) error
 	UpdatePassword(ctx context.Context, id int, pwdHash string) error
+	RehashPassword(ctx context.Context, id int, pwdHash string) error
+	SetMustChangePassword(ctx context.Context, id int, mustChange bool) error
+	UpdateLocale(ctx context.Context, id int, locale string) error
+	RecordFailedLogin(ctx context.Context, id int) (int, error)
+	Lock(ctx context.Context, id int, until time.Time, unlockTokenHash string) error
+	Unlock(ctx context.Context, unlockTokenHash string) (int, error)
+	ResetFailedLogins(ctx context.Context, id int) error
 	Delete(ctx context.Context, id int) error
 }
 
 type userRepo struct {
-	db *sql.DB
+	db DBTX
 }
 
-func NewUserRepo(db *sql.DB) UserRepo {
+func NewUserRepo(db DBTX) UserRepo {
 	return &userRepo{db: db}
 }
 
-func (r *userRepo) Create(ctx context.Context, email, pwdHash string) (*User, error) {
+func (r *userRepo) Create(ctx context.Context, email, pwdHash, locale string) (*User, error) {
 	query := `
-		INSERT INTO users (email, pwd_hash)
-		VALUES ($1, $2)
-		RETURNING id, email, pwd_hash
+		INSERT INTO users (email, pwd_hash, locale)
+		VALUES ($1, $2, $3)
+		RETURNING id, email, pwd_hash, password_changed_at, must_change_password, locale,
+		          failed_logins, last_failed_login_at, locked_until
 	`
 	var user User
-	err := r.db.QueryRowContext(ctx, query, email, pwdHash).Scan(
+	err := r.db.QueryRowContext(ctx, query, email, pwdHash, locale).Scan(
 		&user.ID,
 		&user.Email,
 		&user.PwdHash,
+		&user.PwdChangedAt,
+		&user.MustChangePassword,
+		&user.Locale,
+		&user.FailedLogins,
+		&user.LastFailedLoginAt,
+		&user.LockedUntil,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	return &user, nil
 }
 
 func (r *userRepo) FindByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, email, pwd_hash
+		SELECT id, email, pwd_hash, password_changed_at, must_change_password, locale,
+		       failed_logins, last_failed_login_at, locked_until
 		FROM users
 		WHERE email = $1
 	`
@@ -57,16 +80,23 @@ func (r *userRepo) FindByEmail(ctx context.Context, email string) (*User, error)
 		&user.ID,
 		&user.Email,
 		&user.PwdHash,
+		&user.PwdChangedAt,
+		&user.MustChangePassword,
+		&user.Locale,
+		&user.FailedLogins,
+		&user.LastFailedLoginAt,
+		&user.LockedUntil,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	return &user, nil
 }
 
 func (r *userRepo) FindByID(ctx context.Context, id int) (*User, error) {
 	query := `
-		SELECT id, email, pwd_hash
+		SELECT id, email, pwd_hash, password_changed_at, must_change_password, locale,
+		       failed_logins, last_failed_login_at, locked_until
 		FROM users
 		WHERE id = $1
 	`
@@ -75,9 +105,43 @@ func (r *userRepo) FindByID(ctx context.Context, id int) (*User, error) {
 		&user.ID,
 		&user.Email,
 		&user.PwdHash,
+		&user.PwdChangedAt,
+		&user.MustChangePassword,
+		&user.Locale,
+		&user.FailedLogins,
+		&user.LastFailedLoginAt,
+		&user.LockedUntil,
 	)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
+	}
+	return &user, nil
+}
+
+// FindByIDForUpdate loads the user and locks the row until the end of the
+// transaction. It only makes sense on a repo from TxManager.WithTx.
+func (r *userRepo) FindByIDForUpdate(ctx context.Context, id int) (*User, error) {
+	query := `
+		SELECT id, email, pwd_hash, password_changed_at, must_change_password, locale,
+		       failed_logins, last_failed_login_at, locked_until
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	`
+	var user User
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.PwdHash,
+		&user.PwdChangedAt,
+		&user.MustChangePassword,
+		&user.Locale,
+		&user.FailedLogins,
+		&user.LastFailedLoginAt,
+		&user.LockedUntil,
+	)
+	if err != nil {
+		return nil, dbError(err)
 	}
 	return &user, nil
 }
@@ -89,21 +153,106 @@ func (r *userRepo) UpdateEmail(ctx context.Context, id int, email string) error
 		WHERE id = $2
 	`
 	_, err := r.db.ExecContext(ctx, query, email, id)
-	return err
+	return dbError(err)
 }
 
+// UpdatePassword also lifts any lockout, as whoever set the new password
+// has proven control of the account.
 func (r *userRepo) UpdatePassword(ctx context.Context, id int, pwdHash string) error {
+	query := `
+		UPDATE users
+		SET pwd_hash = $1, password_changed_at = NOW(), must_change_password = FALSE,
+		    failed_logins = 0, locked_until = NULL, unlock_token_hash = NULL
+		WHERE id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, pwdHash, id)
+	return dbError(err)
+}
+
+// RehashPassword replaces the stored hash of an unchanged password, e.g. after
+// an algorithm upgrade, without resetting its age.
+func (r *userRepo) RehashPassword(ctx context.Context, id int, pwdHash string) error {
 	query := `
 		UPDATE users
 		SET pwd_hash = $1
 		WHERE id = $2
 	`
 	_, err := r.db.ExecContext(ctx, query, pwdHash, id)
-	return err
+	return dbError(err)
+}
+
+func (r *userRepo) SetMustChangePassword(ctx context.Context, id int, mustChange bool) error {
+	query := `
+		UPDATE users
+		SET must_change_password = $1
+		WHERE id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, mustChange, id)
+	return dbError(err)
+}
+
+func (r *userRepo) UpdateLocale(ctx context.Context, id int, locale string) error {
+	query := `
+		UPDATE users
+		SET locale = $1
+		WHERE id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, locale, id)
+	return dbError(err)
+}
+
+// RecordFailedLogin counts a failed password attempt and returns the
+// number of failures since the last successful login.
+func (r *userRepo) RecordFailedLogin(ctx context.Context, id int) (int, error) {
+	query := `
+		UPDATE users
+		SET failed_logins = failed_logins + 1, last_failed_login_at = NOW()
+		WHERE id = $1
+		RETURNING failed_logins
+	`
+	var failures int
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&failures)
+	return failures, dbError(err)
+}
+
+func (r *userRepo) Lock(ctx context.Context, id int, until time.Time, unlockTokenHash string) error {
+	query := `
+		UPDATE users
+		SET locked_until = $1, unlock_token_hash = $2
+		WHERE id = $3
+	`
+	_, err := r.db.ExecContext(ctx, query, until, unlockTokenHash, id)
+	return dbError(err)
+}
+
+// Unlock lifts the lockout the unlock token was issued for and returns the
+// user's ID, or ErrNotFound if no account has that token.
+func (r *userRepo) Unlock(ctx context.Context, unlockTokenHash string) (int, error) {
+	query := `
+		UPDATE users
+		SET failed_logins = 0, locked_until = NULL, unlock_token_hash = NULL
+		WHERE unlock_token_hash = $1
+		RETURNING id
+	`
+	var id int
+	if err := r.db.QueryRowContext(ctx, query, unlockTokenHash).Scan(&id); err != nil {
+		return 0, dbError(err)
+	}
+	return id, nil
+}
+
+func (r *userRepo) ResetFailedLogins(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET failed_logins = 0, locked_until = NULL, unlock_token_hash = NULL
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return dbError(err)
 }
 
 func (r *userRepo) Delete(ctx context.Context, id int) error {
 	query := `DELETE FROM users WHERE id = $1`
 	_, err := r.db.ExecContext(ctx, query, id)
-	return err
+	return dbError(err)
 }
@@ -1,12 +1,19 @@
 CREATE TABLE users (
-  id        SERIAL PRIMARY KEY,
-  email     VARCHAR(320) NOT NULL UNIQUE,
-  pwd_hash  CHAR(60) NOT NULL -- bcrypt
+  id                    SERIAL PRIMARY KEY,
+  email                 VARCHAR(320) NOT NULL UNIQUE,
+  pwd_hash              VARCHAR(255) NOT NULL, -- PHC string (argon2id, legacy bcrypt)
+  password_changed_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  must_change_password  BOOLEAN NOT NULL DEFAULT FALSE,
+  locale                VARCHAR(35) NOT NULL DEFAULT '', -- BCP 47, empty = negotiate per request
+  failed_logins         INT NOT NULL DEFAULT 0,
+  last_failed_login_at  TIMESTAMPTZ,
+  locked_until          TIMESTAMPTZ,
+  unlock_token_hash     VARCHAR(64) UNIQUE -- sha256 of the emailed unlock token
 );
 
 CREATE TABLE auth_codes (
   id            SERIAL PRIMARY KEY,
-  code_hash     VARCHAR(60) NOT NULL UNIQUE, -- bcrypt
+  code_hash     CHAR(64) NOT NULL UNIQUE, -- sha256
   user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
   client_id     VARCHAR(64) NOT NULL,
   redirect_uri  VARCHAR(2048) NOT NULL,
@@ -20,7 +27,7 @@ CREATE INDEX auth_codes_uid_idx ON auth_codes(user_id);
 
 CREATE TABLE pwd_reset_tokens (
   id          SERIAL PRIMARY KEY,
-  token_hash  VARCHAR(60) NOT NULL UNIQUE, -- bcrypt
+  token_hash  CHAR(64) NOT NULL UNIQUE, -- sha256
   user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
   expires_at  TIMESTAMPTZ NOT NULL,
   used_at     TIMESTAMPTZ
@@ -28,3 +35,80 @@ CREATE TABLE pwd_reset_tokens (
 
 CREATE INDEX pwd_reset_exp_idx ON pwd_reset_tokens(expires_at);
 CREATE INDEX pwd_reset_uid_idx ON pwd_reset_tokens(user_id);
+
+CREATE TABLE email_change_tokens (
+  id          SERIAL PRIMARY KEY,
+  token_hash  CHAR(64) NOT NULL UNIQUE, -- sha256
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email       VARCHAR(320) NOT NULL, -- new address, not yet confirmed
+  expires_at  TIMESTAMPTZ NOT NULL,
+  used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX email_change_exp_idx ON email_change_tokens(expires_at);
+
+CREATE TABLE sessions (
+  id          SERIAL PRIMARY KEY,
+  token_hash  CHAR(64) NOT NULL UNIQUE, -- sha256
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  client_id   VARCHAR(64) NOT NULL DEFAULT '', -- client signed in through
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at  TIMESTAMPTZ NOT NULL,
+  reauth_at   TIMESTAMPTZ NOT NULL -- last password/MFA verification
+);
+
+CREATE INDEX sessions_exp_idx ON sessions(expires_at);
+CREATE INDEX sessions_uid_idx ON sessions(user_id);
+
+CREATE TABLE pwd_history (
+  id          SERIAL PRIMARY KEY,
+  user_id     INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  pwd_hash    VARCHAR(255) NOT NULL, -- previous pwd_hash values
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX pwd_history_uid_idx ON pwd_history(user_id, created_at);
+
+CREATE TABLE email_outbox (
+  id               SERIAL PRIMARY KEY,
+  recipient        VARCHAR(320) NOT NULL,
+  subject          VARCHAR(998) NOT NULL,
+  text_body        TEXT NOT NULL,
+  html_body        TEXT NOT NULL,
+  status           VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending | dead
+  attempts         INT NOT NULL DEFAULT 0,
+  last_error       TEXT,
+  next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_until     TIMESTAMPTZ,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX email_outbox_due_idx ON email_outbox(status, next_attempt_at);
+
+CREATE TABLE email_suppressions (
+  email       VARCHAR(320) PRIMARY KEY, -- lowercased
+  reason      VARCHAR(16) NOT NULL, -- bounce | complaint
+  detail      TEXT NOT NULL DEFAULT '',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE rate_limits (
+  key         TEXT PRIMARY KEY, -- <limiter>:<ip|email|client>
+  value       DOUBLE PRECISION NOT NULL DEFAULT 0,
+  prev        DOUBLE PRECISION NOT NULL DEFAULT 0,
+  stamp       TIMESTAMPTZ,
+  expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX rate_limits_exp_idx ON rate_limits(expires_at);
+
+CREATE TABLE signup_tokens (
+  id          SERIAL PRIMARY KEY,
+  token_hash  VARCHAR(64) NOT NULL UNIQUE, -- sha256
+  email       VARCHAR(320) NOT NULL,
+  expires_at  TIMESTAMPTZ NOT NULL,
+  used_at     TIMESTAMPTZ
+);
+
+CREATE INDEX signup_tokens_exp_idx ON signup_tokens(expires_at);
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/yookibooki/auth/apperror"
+)
+
+// ErrorResponse is the body of every JSON error, see apperror.Error.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SendsJSON reports whether the body of r is JSON.
+func SendsJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// WantsJSON reports whether r should be answered with JSON instead of a
+// page: it sent JSON, or it accepts JSON. Browsers do neither.
+func WantsJSON(r *http.Request) bool {
+	return SendsJSON(r) || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	WriteJSON(w, status, ErrorResponse{Error: ErrorDetail{Code: code, Message: message}})
+}
+
+// Error replies like http.Error, or with an ErrorResponse to JSON requests.
+func Error(w http.ResponseWriter, r *http.Request, message string, status int) {
+	RenderError(w, r, apperror.New(status, message))
+}
+
+// RenderError answers r with the status and user-safe message err maps to,
+// see apperror.From, as plain text or an ErrorResponse. Server errors are
+// logged with their cause.
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperror.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, e)
+	}
+
+	if !WantsJSON(r) {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	WriteError(w, e.Status, e.Code, e.Message)
+}
@@ -1,14 +1,144 @@
 package web
 
 import (
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yookibooki/auth/clients"
+	"github.com/yookibooki/auth/i18n"
+	"github.com/yookibooki/auth/pow"
 )
 
-func Parse() *template.Template {
-	tmpl, err := template.ParseGlob("web/*.html")
+// Templates holds one template set per page. Every page defines the same
+// "title" and "content" blocks, so they cannot share a single set.
+type Templates struct {
+	pages   map[string]*template.Template
+	clients map[string]*Templates
+}
+
+// Page is embedded in the data of every page. base.html and the pages
+// translate their strings with {{ .L.T "key" }} and style themselves with
+// the requesting client's Brand. Forms protected by a proof of work include
+// {{ template "pow" . }}, which is empty unless Challenge is set. Every
+// form posting to the service includes {{ template "csrf" . }}, and every
+// <script> and <style> element carries nonce="{{ .CSPNonce }}".
+//
+// Only Challenge is part of the JSON form of a page, see Render.
+type Page struct {
+	L         *i18n.Localizer  `json:"-"`
+	Brand     clients.Branding `json:"-"`
+	Challenge pow.Challenge    `json:"challenge,omitzero"`
+	CSRFToken string           `json:"-"`
+	CSPNonce  string           `json:"-"`
+}
+
+func Parse() *Templates {
+	t, err := parse("web", "")
 	if err != nil {
 		log.Fatalf("Failed to parse templates: %v", err)
 	}
-	return tmpl
+	return t
+}
+
+// parse loads every page in dir. Pages and base.html found in overrideDir
+// replace their counterpart from dir.
+func parse(dir, overrideDir string) (*Templates, error) {
+	pick := func(name string) string {
+		if overrideDir != "" {
+			if path := filepath.Join(overrideDir, name); fileExists(path) {
+				return path
+			}
+		}
+		return filepath.Join(dir, name)
+	}
+
+	base, err := template.ParseFiles(pick("base.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*template.Template)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == "base.html" {
+			continue
+		}
+
+		page, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if page, err = page.ParseFiles(pick(name)); err != nil {
+			return nil, err
+		}
+		pages[name] = page
+	}
+
+	return &Templates{pages: pages, clients: make(map[string]*Templates)}, nil
+}
+
+// AddClientOverrides parses a template set for clientID in which pages
+// found in dir replace the default ones.
+func (t *Templates) AddClientOverrides(clientID, dir string) error {
+	overrides, err := parse("web", dir)
+	if err != nil {
+		return fmt.Errorf("failed to parse templates for client %q: %w", clientID, err)
+	}
+	t.clients[clientID] = overrides
+	return nil
+}
+
+// For returns the template set for clientID, which is t itself unless the
+// client overrides any pages.
+func (t *Templates) For(clientID string) *Templates {
+	if overrides, ok := t.clients[clientID]; ok {
+		return overrides
+	}
+	return t
+}
+
+func (t *Templates) ExecuteTemplate(w io.Writer, name string, data any) error {
+	page, ok := t.pages[name]
+	if !ok {
+		return fmt.Errorf("template %q not found", name)
+	}
+	return page.ExecuteTemplate(w, name, data)
+}
+
+// Render executes the page name, or answers JSON requests with data and a
+// "page" field naming the page, e.g. {"page": "link-sent"}.
+func (t *Templates) Render(w http.ResponseWriter, r *http.Request, name string, data any) error {
+	if !WantsJSON(r) {
+		return t.ExecuteTemplate(w, name, data)
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+	fields["page"] = strings.TrimSuffix(name, ".html")
+
+	WriteJSON(w, http.StatusOK, fields)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }